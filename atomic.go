@@ -0,0 +1,103 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+)
+
+// 以下操作在持有db.mu时完成读取和写入，与其他写操作以及WriteBatch的提交之间是原子的
+// 超过磁盘限制时与Put一样等待空间释放，每次重试都重新读取当前的值
+
+// CompareAndSwap 当key当前的值等于old时写入new，old为nil表示key不存在，返回是否写入成功
+func (db *DB) CompareAndSwap(key, old, new []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	var swapped bool
+	err := db.retryOnDiskQuota(context.Background(), "compare and swap", func() error {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		value, err := db.getValue(key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err == ErrKeyNotFound {
+			if old != nil {
+				return nil
+			}
+		} else if old == nil || !bytes.Equal(value, old) {
+			return nil
+		}
+
+		if err := db.putValue(key, new); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	})
+	return swapped, err
+}
+
+// PutIfAbsent key不存在时写入，返回是否写入成功
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	return db.CompareAndSwap(key, nil, value)
+}
+
+// Increment 将key的值加上delta并返回结果，值按8字节大端序的int64编码，key不存在时从0开始
+func (db *DB) Increment(key []byte, delta int64) (int64, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+
+	var counter int64
+	err := db.retryOnDiskQuota(context.Background(), "increment", func() error {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		counter = 0
+		value, err := db.getValue(key)
+		switch {
+		case err == ErrKeyNotFound:
+		case err != nil:
+			return err
+		case len(value) != 8:
+			return ErrValueNotInteger
+		default:
+			counter = int64(binary.BigEndian.Uint64(value))
+		}
+
+		counter += delta
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, uint64(counter))
+		return db.putValue(key, buf)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
+
+// Append 在key的值后面追加suffix，key不存在时直接写入suffix
+func (db *DB) Append(key, suffix []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	return db.retryOnDiskQuota(context.Background(), "append", func() error {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		value, err := db.getValue(key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+
+		newValue := make([]byte, 0, len(value)+len(suffix))
+		newValue = append(newValue, value...)
+		newValue = append(newValue, suffix...)
+		return db.putValue(key, newValue)
+	})
+}
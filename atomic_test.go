@@ -0,0 +1,88 @@
+package bitcask_go
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_AtomicOperations(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-atomic")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// CompareAndSwap
+	ok, err := db.CompareAndSwap([]byte("cas"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap([]byte("cas"), nil, []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.CompareAndSwap([]byte("cas"), nil, []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap([]byte("cas"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.CompareAndSwap(nil, nil, []byte("b"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// PutIfAbsent
+	ok, err = db.PutIfAbsent([]byte("absent"), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent([]byte("absent"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get([]byte("absent"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// Increment
+	n, err := db.Increment([]byte("counter"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = db.Increment([]byte("counter"), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+	_, err = db.Increment([]byte("cas"), 1)
+	assert.Equal(t, ErrValueNotInteger, err)
+
+	// Append
+	assert.Nil(t, db.Append([]byte("append"), []byte("a")))
+	assert.Nil(t, db.Append([]byte("append"), []byte("bc")))
+	val, err = db.Get([]byte("append"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), val)
+
+	// 并发更新不会丢失
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.Increment([]byte("concurrent"), 1)
+				assert.Nil(t, err)
+				assert.Nil(t, db.Append([]byte("concurrent-append"), []byte("x")))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	n, err = db.Increment([]byte("concurrent"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(800), n)
+	val, err = db.Get([]byte("concurrent-append"))
+	assert.Nil(t, err)
+	assert.Equal(t, 800, len(val))
+	assert.Nil(t, db.Close())
+}
@@ -2,6 +2,8 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
+	"context"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
@@ -11,12 +13,25 @@ const nonTransactionSeqNo uint64 = 0
 
 var txnFinKey = []byte("txn_fin")
 
-// WriteBatch 原子批量写数据 保证原子性
+// WriteBatch 原子批量写数据 保证原子性，一个批次可以同时写入多个命名空间
 type WriteBatch struct {
 	options       WriteBatchOptions
 	mu            *sync.Mutex
 	db            *DB
-	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	pendingWrites map[string]*pendingWrite // 暂存用户写入的数据，key由命名空间id和key组成
+}
+
+// pendingWrite 暂存的一条数据，ns为nil时属于默认命名空间
+type pendingWrite struct {
+	ns     *Namespace
+	record *data.LogRecord
+}
+
+func (pw *pendingWrite) nsId() uint32 {
+	if pw.ns == nil {
+		return defaultNamespaceId
+	}
+	return pw.ns.id
 }
 
 // NewWriteBatch 初始化 WriteBatch
@@ -29,12 +44,21 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 		options:       opts,
 		mu:            new(sync.Mutex),
 		db:            db,
-		pendingWrites: make(map[string]*data.LogRecord),
+		pendingWrites: make(map[string]*pendingWrite),
 	}
 }
 
 // Put 批量写数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.put(nil, key, value)
+}
+
+// PutIn 批量写数据到命名空间ns中
+func (wb *WriteBatch) PutIn(ns *Namespace, key []byte, value []byte) error {
+	return wb.put(ns, key, value)
+}
+
+func (wb *WriteBatch) put(ns *Namespace, key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
@@ -42,40 +66,61 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	defer wb.mu.Unlock()
 
 	// 暂存 LogRecord
-	logRecord := &data.LogRecord{Key: key, Value: value}
-	wb.pendingWrites[string(key)] = logRecord
+	write := &pendingWrite{ns: ns, record: &data.LogRecord{Key: key, Value: value}}
+	wb.pendingWrites[batchKey(write.nsId(), key)] = write
 
 	return nil
 }
 
 // Delete 删除数据
 func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.delete(nil, key)
+}
+
+// DeleteIn 删除命名空间ns中的数据
+func (wb *WriteBatch) DeleteIn(ns *Namespace, key []byte) error {
+	return wb.delete(ns, key)
+}
+
+func (wb *WriteBatch) delete(ns *Namespace, key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
+	write := &pendingWrite{ns: ns, record: &data.LogRecord{Key: key, Type: data.LogRecordDeleted}}
+	pendingKey := batchKey(write.nsId(), key)
+
 	// 数据不存在直接返回
-	logRecordPos := wb.db.index.Get(key)
+	idx := wb.db.index
+	if ns != nil {
+		idx = ns.index
+	}
+	logRecordPos := wb.db.indexGet(idx, key)
 	if logRecordPos == nil {
-		if wb.pendingWrites[string(key)] != nil {
-			delete(wb.pendingWrites, string(key))
+		if wb.pendingWrites[pendingKey] != nil {
+			delete(wb.pendingWrites, pendingKey)
 		}
 		return nil
 	}
 
 	// 暂存LogRecord
-	logRecord := &data.LogRecord{
-		Key:  key,
-		Type: data.LogRecordDeleted,
-	}
-	wb.pendingWrites[string(key)] = logRecord
+	wb.pendingWrites[pendingKey] = write
 	return nil
 }
 
 // Commit 提交事务，将暂存的数据写到数据文件，并更新内存索引
 func (wb *WriteBatch) Commit() error {
+	return wb.CommitCtx(context.Background())
+}
+
+// CommitCtx 提交事务，只在写入数据之前检查ctx，开始写入之后会完整地提交事务
+func (wb *WriteBatch) CommitCtx(ctx context.Context) error {
+	if err := checkContext(ctx, "commit"); err != nil {
+		return err
+	}
+
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -86,26 +131,69 @@ func (wb *WriteBatch) Commit() error {
 	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
 		return ErrMaxBatchNumExceeded
 	}
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 
+	return wb.db.retryOnDiskQuota(ctx, "commit", func() error {
+		return wb.commit(ctx)
+	})
+}
+
+// commit 持有db.mu写入整个批次并更新索引，需要持有wb.mu
+func (wb *WriteBatch) commit(ctx context.Context) error {
 	// db加锁保证事务提交串行化
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
+	// 等待锁的期间ctx可能已经结束，写入之前再检查一次
+	if err := checkContext(ctx, "commit"); err != nil {
+		return err
+	}
+
+	// 任意一个命名空间已经被删除时整个批次都不写入
+	for _, write := range wb.pendingWrites {
+		if write.ns != nil && write.ns.dropped {
+			return ErrNamespaceDropped
+		}
+	}
+
+	// 整个批次一起检查磁盘限制，避免写入一部分之后失败
+	var batchSize int64
+	for _, write := range wb.pendingWrites {
+		if logRecordNeedsQuota(write.record) {
+			batchSize += int64(len(write.record.Key)+len(write.record.Value)) + logRecordOverhead
+		}
+	}
+	if batchSize > 0 {
+		if err := wb.db.checkDiskQuota(batchSize); err != nil {
+			return err
+		}
+	}
+
 	// 获取当前最新的事务序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
 
 	// 开始写数据到数据文件中
 	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
-		})
+	var firstFid uint32
+	for pendingKey, write := range wb.pendingWrites {
+		logRecord := &data.LogRecord{
+			Key:   logRecordKeyWithSeq(write.record.Key, seqNo),
+			Value: write.record.Value,
+			Type:  write.record.Type,
+		}
+		joinNamespace(logRecord, write.nsId())
+		logRecordPos, err := wb.db.writeLogRecord(logRecord, false)
 		if err != nil {
+			// 已经写入的记录没有事务完成标记，重启时会被忽略
+			wb.db.logger.Warn("write batch failed", "seqNo", seqNo, "written", len(positions), "err", err)
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		if len(positions) == 0 {
+			firstFid = logRecordPos.Fid
+		}
+		positions[pendingKey] = logRecordPos
 	}
 
 	// 写一条标识事务完成的数据
@@ -114,35 +202,56 @@ func (wb *WriteBatch) Commit() error {
 		Type: data.LogRecordTxnFinished,
 	}
 
-	if _, err := wb.db.appendLogRecord(finishLogRecord); err != nil {
+	finishPos, err := wb.db.writeLogRecord(finishLogRecord, false)
+	if err != nil {
+		wb.db.logger.Warn("write batch failed", "seqNo", seqNo, "written", len(positions), "err", err)
 		return err
 	}
+	// 事务跨越了数据文件，文件压缩时需要一起处理
+	if finishPos.Fid != firstFid {
+		wb.db.txnSpans[finishPos.Fid] = firstFid
+	}
 
 	// 根据配置是否需要持久化
 	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+		if err := wb.db.syncActiveFile(); err != nil {
 			return err
 		}
 	}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
-		}
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+	// 按命名空间批量更新索引，B+树索引只需要提交一次事务
+	nsOps := make(map[uint32][]index.BatchOp)
+	for pendingKey, write := range wb.pendingWrites {
+		nsOps[write.nsId()] = append(nsOps[write.nsId()], index.BatchOp{
+			Key:  write.record.Key,
+			Pos:  positions[pendingKey],
+			Type: write.record.Type,
+		})
+	}
+	for nsId, ops := range nsOps {
+		for i, oldPos := range wb.db.namespaceIndex(nsId).ApplyBatch(ops) {
+			if ops[i].Type == data.LogRecordDeleted {
+				wb.db.addReclaimSize(ops[i].Pos)
+			}
+			wb.db.addReclaimSize(oldPos)
 		}
+	}
 
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+	// 更新二级索引，和主索引在同一次持有锁的期间完成
+	for _, write := range wb.pendingWrites {
+		if write.ns != nil {
+			continue
+		}
+		if write.record.Type == data.LogRecordDeleted {
+			wb.db.removeFromSecondaryIndexes(write.record.Key)
+		} else {
+			wb.db.updateSecondaryIndexes(write.record.Key, write.record.Value)
 		}
 	}
 
+	wb.db.logger.Debug("write batch committed", "seqNo", seqNo, "records", len(wb.pendingWrites))
 	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.pendingWrites = make(map[string]*pendingWrite)
 
 	return nil
 }
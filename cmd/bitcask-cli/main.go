@@ -0,0 +1,77 @@
+// bitcask-cli 交互式查看以及修改数据目录
+//
+//	bitcask-cli -dir /tmp/bitcask-go
+//	bitcask-cli -dir /tmp/bitcask-go -index bptree
+//	echo "scan -prefix user:" | bitcask-cli -dir /tmp/bitcask-go
+//
+// 默认以只读方式打开，不会创建或者修改数据目录中的文件，-write开启写入以及merge；-index需要与写入时使用的索引类型一致；从标准输入读取命令，标准输入不是终端时不输出提示符，
+// 任意命令失败时以状态码1退出，便于在脚本中使用
+package main
+
+import (
+	bitcask "bitcask-go"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	dir := flag.String("dir", "", "data directory")
+	write := flag.Bool("write", false, "open the data directory in read-write mode")
+	format := flag.String("format", formatUTF8, "key and value format, utf8 or hex")
+	indexName := flag.String("index", "btree", "index type, btree, art, bptree, compact or keyhash")
+	flag.Parse()
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "missing -dir")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != formatUTF8 && *format != formatHex {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(2)
+	}
+	indexType, known := indexTypes[*indexName]
+	if !known {
+		fmt.Fprintf(os.Stderr, "unknown index type %q\n", *indexName)
+		os.Exit(2)
+	}
+
+	opts := bitcask.DefaultOptions
+	opts.DirPath = *dir
+	opts.ReadOnly = !*write
+	opts.IndexType = indexType
+	db, err := bitcask.Open(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", *dir, err)
+		os.Exit(1)
+	}
+
+	sh := &shell{db: db, dir: *dir, out: os.Stdout, hex: *format == formatHex}
+	ok := sh.run(os.Stdin, os.Stderr, isTerminal(os.Stdin))
+	if err := db.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to close %s: %v\n", *dir, err)
+		ok = false
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// indexTypes -index可以使用的索引类型
+var indexTypes = map[string]bitcask.IndexerType{
+	"btree":   bitcask.BTree,
+	"art":     bitcask.ART,
+	"bptree":  bitcask.BPlusTree,
+	"compact": bitcask.CompactBTree,
+	"keyhash": bitcask.KeyHash,
+}
+
+// isTerminal 标准输入是否是终端，脚本通过管道输入时不输出提示符
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
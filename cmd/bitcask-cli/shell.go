@@ -0,0 +1,442 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	formatUTF8 = "utf8"
+	formatHex  = "hex"
+)
+
+const usage = `commands:
+  get <key>                      print the value of key
+  put <key> <value>              write key, needs -write
+  del <key>                      delete key, needs -write
+  scan [-prefix p] [-start s] [-end e] [-limit n] [-reverse]
+                                 print keys and values, the range is [start, end)
+  keys [-prefix p] [-start s] [-end e] [-limit n] [-reverse]
+                                 print keys only
+  stat                           print database statistics
+  merge                          merge data files, needs -write
+  backup <dir>                   copy the data directory to dir
+  dump <fileId> [-offset n] [-limit n]
+                                 print raw records of a data file with offsets, crc and type
+  format [utf8|hex]              show or change the format of keys and values
+  help                           print this help
+  exit                           quit
+arguments can be double quoted with Go escapes such as "a b\x00",
+in utf8 format keys and values that are not printable are printed quoted`
+
+// errExit 退出命令
+var errExit = errors.New("exit")
+
+// shell 解析并执行命令，输出写入out
+type shell struct {
+	db  *bitcask.DB
+	dir string
+	out io.Writer
+	hex bool // 参数按十六进制解析，key和value按十六进制输出
+}
+
+// run 依次执行in中的每一行，错误写入errOut，返回是否全部执行成功
+func (s *shell) run(in io.Reader, errOut io.Writer, interactive bool) bool {
+	ok := true
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for {
+		if interactive {
+			fmt.Fprint(s.out, "bitcask> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		err := s.exec(scanner.Text())
+		if err == errExit {
+			return ok
+		}
+		if err != nil {
+			fmt.Fprintf(errOut, "error: %v\n", err)
+			ok = false
+		}
+	}
+	if interactive {
+		fmt.Fprintln(s.out)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(errOut, "error: %v\n", err)
+		ok = false
+	}
+	return ok
+}
+
+// exec 执行一行命令，空行以及#开头的注释直接忽略
+func (s *shell) exec(line string) error {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return nil
+	}
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "get":
+		return s.get(args)
+	case "put":
+		return s.put(args)
+	case "del":
+		return s.del(args)
+	case "scan":
+		return s.scan(cmd, args, true)
+	case "keys":
+		return s.scan(cmd, args, false)
+	case "stat":
+		return s.stat(args)
+	case "merge":
+		return s.merge(args)
+	case "backup":
+		return s.backup(args)
+	case "dump":
+		return s.dump(args)
+	case "format":
+		return s.setFormat(args)
+	case "help":
+		fmt.Fprintln(s.out, usage)
+		return nil
+	case "exit", "quit":
+		return errExit
+	default:
+		return fmt.Errorf("unknown command %q, type help for usage", cmd)
+	}
+}
+
+func (s *shell) get(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: get <key>")
+	}
+	key, err := s.parse(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := s.db.Get(key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, s.format(value))
+	return nil
+}
+
+func (s *shell) put(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: put <key> <value>")
+	}
+	key, err := s.parse(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := s.parse(args[1])
+	if err != nil {
+		return err
+	}
+	return s.ok(s.db.Put(key, value))
+}
+
+func (s *shell) del(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: del <key>")
+	}
+	key, err := s.parse(args[0])
+	if err != nil {
+		return err
+	}
+	return s.ok(s.db.Delete(key))
+}
+
+// scan 按前缀以及[start, end)范围遍历，withValues为false时只输出key
+func (s *shell) scan(cmd string, args []string, withValues bool) error {
+	fs := newFlagSet(cmd)
+	prefixArg := fs.String("prefix", "", "")
+	startArg := fs.String("start", "", "")
+	endArg := fs.String("end", "", "")
+	limit := fs.Int("limit", 0, "")
+	reverse := fs.Bool("reverse", false, "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	prefix, err := s.parse(*prefixArg)
+	if err != nil {
+		return err
+	}
+	start, err := s.parse(*startArg)
+	if err != nil {
+		return err
+	}
+	end, err := s.parse(*endArg)
+	if err != nil {
+		return err
+	}
+
+	it := s.db.NewIterator(bitcask.IteratorOptions{Prefix: prefix, Reverse: *reverse})
+	defer it.Close()
+	// 正序从start开始，逆序从end开始，等于end的key不在范围内
+	switch {
+	case !*reverse && len(start) > 0:
+		it.Seek(start)
+	case *reverse && len(end) > 0:
+		it.Seek(end)
+	default:
+		it.Rewind()
+	}
+
+	var n int
+	for ; it.Valid() && (*limit <= 0 || n < *limit); it.Next() {
+		key := it.Key()
+		if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+			if *reverse {
+				continue
+			}
+			break
+		}
+		if len(start) > 0 && bytes.Compare(key, start) < 0 {
+			break
+		}
+		if !withValues {
+			fmt.Fprintln(s.out, s.format(key))
+		} else {
+			value, err := it.Value()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(s.out, "%s\t%s\n", s.format(key), s.format(value))
+		}
+		n++
+	}
+	return nil
+}
+
+func (s *shell) stat(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: stat")
+	}
+	stat := s.db.Stat()
+	fmt.Fprintf(s.out, "keys\t%d\n", stat.KeyNum)
+	fmt.Fprintf(s.out, "data files\t%d\n", stat.DataFileNum)
+	fmt.Fprintf(s.out, "reclaimable bytes\t%d\n", stat.ReclaimableSize)
+	fmt.Fprintf(s.out, "disk bytes\t%d\n", stat.DiskSize)
+	fmt.Fprintf(s.out, "namespaces\t%s\n", strings.Join(s.db.ListNamespaces(), ","))
+	return nil
+}
+
+func (s *shell) merge(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: merge")
+	}
+	return s.ok(s.db.Merge())
+}
+
+func (s *shell) backup(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: backup <dir>")
+	}
+	return s.ok(s.db.Backup(args[0]))
+}
+
+// dump 从offset开始输出数据文件中的原始记录，遇到损坏的记录时返回错误
+func (s *shell) dump(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("usage: dump <fileId> [-offset n] [-limit n]")
+	}
+	fileId, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file id %q", args[0])
+	}
+	fs := newFlagSet("dump")
+	offset := fs.Int64("offset", 0, "")
+	limit := fs.Int("limit", 0, "")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	// 以只读方式打开，不会创建不存在的文件，也不需要数据目录的写权限
+	dataFile, err := data.OpenDataFile(s.dir, uint32(fileId), fio.ReadOnlyFIO)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	fmt.Fprintln(s.out, "offset\tsize\ttype\tcrc\tnamespace\tseq\tkey\tvalue")
+	off, n := *offset, 0
+	crcBuf := make([]byte, 4)
+	for *limit <= 0 || n < *limit {
+		record, size, err := dataFile.ReadLogRecord(off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", off, err)
+		}
+		if _, err := dataFile.IOManager.Read(crcBuf, off); err != nil {
+			return fmt.Errorf("offset %d: %w", off, err)
+		}
+		nsId, seqNo, key := decodeRecordKey(record)
+		fmt.Fprintf(s.out, "%d\t%d\t%s\t%08x\t%d\t%d\t%s\t%s\n", off, size, recordTypeName(record.Type),
+			binary.LittleEndian.Uint32(crcBuf), nsId, seqNo, s.format(key), s.format(record.Value))
+		off += size
+		n++
+	}
+	fmt.Fprintf(s.out, "%d records, next offset %d\n", n, off)
+	return nil
+}
+
+func (s *shell) setFormat(args []string) error {
+	switch {
+	case len(args) == 0:
+		if s.hex {
+			fmt.Fprintln(s.out, formatHex)
+		} else {
+			fmt.Fprintln(s.out, formatUTF8)
+		}
+	case len(args) == 1 && args[0] == formatUTF8:
+		s.hex = false
+	case len(args) == 1 && args[0] == formatHex:
+		s.hex = true
+	default:
+		return errors.New("usage: format [utf8|hex]")
+	}
+	return nil
+}
+
+// ok 执行成功时输出OK
+func (s *shell) ok(err error) error {
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, "OK")
+	return nil
+}
+
+// parse 解析key或者value参数
+func (s *shell) parse(arg string) ([]byte, error) {
+	if !s.hex {
+		return []byte(arg), nil
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(arg, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex argument %q", arg)
+	}
+	return b, nil
+}
+
+// format 格式化key或者value，utf8格式下不可打印的内容输出为带引号的转义字符串，可以直接作为参数使用
+func (s *shell) format(b []byte) string {
+	if s.hex {
+		return hex.EncodeToString(b)
+	}
+	if len(b) == 0 || b[0] == '"' || !utf8.Valid(b) {
+		return strconv.Quote(string(b))
+	}
+	for _, r := range string(b) {
+		if !strconv.IsPrint(r) {
+			return strconv.Quote(string(b))
+		}
+	}
+	return string(b)
+}
+
+// decodeRecordKey 解析数据文件中记录的key，返回命名空间id、事务序列号以及用户的key
+func decodeRecordKey(record *data.LogRecord) (uint32, uint64, []byte) {
+	key := record.Key
+	var nsId uint64
+	if record.Type&data.LogRecordNamespaceFlag != 0 {
+		var n int
+		nsId, n = binary.Uvarint(key)
+		key = key[n:]
+	}
+	seqNo, n := binary.Uvarint(key)
+	return uint32(nsId), seqNo, key[n:]
+}
+
+func recordTypeName(recordType data.LogRecordType) string {
+	switch recordType &^ data.LogRecordNamespaceFlag {
+	case data.LogRecordNormal:
+		return "normal"
+	case data.LogRecordDeleted:
+		return "deleted"
+	case data.LogRecordTxnFinished:
+		return "txn-finished"
+	case data.LogRecordMergeOperand:
+		return "merge-operand"
+	default:
+		return fmt.Sprintf("unknown(%d)", recordType)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseFlags 解析命令的选项，不接受多余的参数
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s: %v", fs.Name(), err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%s: unexpected argument %q", fs.Name(), fs.Arg(0))
+	}
+	return nil
+}
+
+// splitArgs 按空白切分参数，双引号中的参数按Go字符串的转义规则解析
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+		case '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return nil, errors.New("unterminated quoted argument")
+			}
+			arg, err := strconv.Unquote(line[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted argument %s", line[i:j+1])
+			}
+			args = append(args, arg)
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && line[j] != ' ' && line[j] != '\t' {
+				j++
+			}
+			args = append(args, line[i:j])
+			i = j
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cli")
+	defer os.RemoveAll(dir)
+	opts := bitcask.DefaultOptions
+	opts.DirPath = dir
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+
+	var out, errOut bytes.Buffer
+	sh := &shell{db: db, dir: dir, out: &out}
+	script := `
+# 注释以及空行被忽略
+put a 1
+put b "two words"
+put c "\x00\x01"
+put d 4
+del d
+get b
+scan
+keys -start b -end c
+scan -reverse -end c -limit 1
+format hex
+get 63
+format utf8
+dump 0 -limit 2
+`
+	assert.True(t, sh.run(strings.NewReader(script), &errOut, false))
+	assert.Equal(t, "", errOut.String())
+	assert.Equal(t, strings.Join([]string{
+		"OK", "OK", "OK", "OK", "OK",
+		"two words",
+		"a\t1", "b\ttwo words", "c\t\"\\x00\\x01\"",
+		"b",
+		"b\ttwo words",
+		"0001",
+		"offset\tsize\ttype\tcrc\tnamespace\tseq\tkey\tvalue",
+	}, "\n"), strings.Join(strings.Split(out.String(), "\n")[:13], "\n"))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.True(t, strings.HasPrefix(lines[13], "0\t"))
+	assert.Contains(t, lines[13], "\tnormal\t")
+	assert.True(t, strings.HasSuffix(lines[13], "\ta\t1"))
+	assert.True(t, strings.HasPrefix(lines[15], "2 records, next offset "))
+
+	// 失败的命令不影响之后的命令
+	out.Reset()
+	assert.False(t, sh.run(strings.NewReader("get d\nunknown\nget a\nexit\nget b\n"), &errOut, false))
+	assert.Equal(t, "1\n", out.String())
+	assert.Equal(t, 2, strings.Count(errOut.String(), "error: "))
+	assert.Nil(t, db.Close())
+
+	// 只读方式打开时不能写入
+	opts.ReadOnly = true
+	db, err = bitcask.Open(opts)
+	assert.Nil(t, err)
+	out.Reset()
+	errOut.Reset()
+	sh = &shell{db: db, dir: dir, out: &out}
+	assert.False(t, sh.run(strings.NewReader("get a\nput a 2\nmerge\n"), &errOut, false))
+	assert.Equal(t, "1\n", out.String())
+	assert.Equal(t, 2, strings.Count(errOut.String(), bitcask.ErrReadOnly.Error()))
+	assert.Nil(t, db.Close())
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(` put  "a b" c\d "\"q\"" `)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"put", "a b", `c\d`, `"q"`}, args)
+
+	_, err = splitArgs(`put "a`)
+	assert.NotNil(t, err)
+}
+
+func TestShellReadOnlyBPlusTree(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cli")
+	defer os.RemoveAll(dir)
+	opts := bitcask.DefaultOptions
+	opts.DirPath = dir
+	opts.IndexType = indexTypes["bptree"]
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Close())
+
+	// B+树索引的数据目录同样可以只读打开
+	opts.ReadOnly = true
+	db, err = bitcask.Open(opts)
+	assert.Nil(t, err)
+	var out, errOut bytes.Buffer
+	sh := &shell{db: db, dir: dir, out: &out}
+	assert.True(t, sh.run(strings.NewReader("get a\nkeys\n"), &errOut, false))
+	assert.Equal(t, "1\na\n", out.String())
+	assert.Nil(t, db.Close())
+}
+
+func TestShellDumpReadOnly(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cli")
+	defer os.RemoveAll(dir)
+	opts := bitcask.DefaultOptions
+	opts.DirPath = dir
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Close())
+
+	// 没有开启-write时，dump不会在没有写权限的数据目录中创建或者修改文件
+	assert.Nil(t, os.Chmod(dir, 0500))
+	defer os.Chmod(dir, 0700)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	opts.ReadOnly = true
+	db, err = bitcask.Open(opts)
+	assert.Nil(t, err)
+	var out, errOut bytes.Buffer
+	sh := &shell{db: db, dir: dir, out: &out}
+	assert.False(t, sh.run(strings.NewReader("dump 0\ndump 7\n"), &errOut, false))
+	assert.Contains(t, out.String(), "1 records")
+	assert.Contains(t, errOut.String(), "no such file or directory")
+	assert.Nil(t, db.Close())
+
+	after, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(after))
+	_, err = os.Stat(data.GetDataFileName(dir, 7))
+	assert.True(t, os.IsNotExist(err))
+}
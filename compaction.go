@@ -0,0 +1,480 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// compactEntry 压缩时重写的一条记录
+type compactEntry struct {
+	nsId   uint32
+	key    []byte
+	oldPos *data.LogRecordPos
+	newPos *data.LogRecordPos
+	typ    data.LogRecordType
+}
+
+// compactWriter 将有效数据写入到预留id的临时文件中，每个数据文件同时生成对应的hint文件
+type compactWriter struct {
+	dirPath    string
+	fileSize   int64
+	nextFileId uint32 // 下一个可以使用的文件id
+	maxFileId  uint32 // 预留的最大文件id
+	dataFile   *data.DataFile
+	hintFile   *data.DataFile
+	fileIds    []uint32 // 已经生成的文件id
+	writeOff   int64    // 当前文件写入的偏移
+	dryRun     bool     // 只统计需要的文件数量，不写入文件
+}
+
+// CompactFiles 文件级别的压缩，挑选待回收数据占比最高的maxFiles个旧数据文件，只重写其中的有效数据
+// 与Merge相比，每次只需要重写少量文件，需要的磁盘空间和IO都更少；压缩完成后旧的数据文件会被删除
+func (db *DB) CompactFiles(maxFiles int) (err error) {
+	if maxFiles <= 0 {
+		return errors.New("invalid compact file num, must be greater than 0")
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
+	// 如果数据库为空 直接返回
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	// 与merge互斥
+	if db.isMerge {
+		db.mu.Unlock()
+		return ErrMergeInProgress
+	}
+
+	compactFiles, err := db.pickCompactFiles(maxFiles)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if len(compactFiles) == 0 {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+
+	db.isMerge = true
+	defer func() {
+		db.mu.Lock()
+		db.isMerge = false
+		db.mu.Unlock()
+	}()
+	indexes := db.namespaceIndexes()
+	db.mu.Unlock()
+
+	info := CompactionInfo{FileIds: make([]uint32, 0, len(compactFiles))}
+	for _, dataFile := range compactFiles {
+		size, err := dataFile.IOManager.Size()
+		if err != nil {
+			return err
+		}
+		info.FileIds = append(info.FileIds, dataFile.FileId)
+		info.TotalSize += size
+	}
+	db.logger.Info("compaction begin", "dir", db.options.DirPath, "files", info.FileIds, "size", info.TotalSize)
+	if fn := db.options.EventListener.OnCompactionBegin; fn != nil {
+		fn(info)
+	}
+	start := time.Now()
+	defer func() {
+		info.Duration, info.Err = time.Since(start), err
+		if err != nil {
+			db.logger.Error("compaction failed", "dir", db.options.DirPath, "duration", info.Duration, "err", err)
+		} else {
+			db.logger.Info("compaction end", "dir", db.options.DirPath, "duration", info.Duration)
+		}
+		if fn := db.options.EventListener.OnCompactionEnd; fn != nil {
+			fn(info)
+		}
+	}()
+
+	// 合并操作数之后的值可能比原来的记录大，先按照重写的方式统计需要的文件数量
+	// 被压缩的文件不会再写入，其中的有效数据只会减少，所以统计的数量足够之后的重写使用
+	counter := &compactWriter{fileSize: db.options.DataFileSize, maxFileId: math.MaxUint32, dryRun: true}
+	if _, err := db.rewriteDataFiles(compactFiles, indexes, counter, nil); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	// 持久化当前活跃文件
+	if err := db.syncActiveFile(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+
+	// 在当前活跃文件之后预留一段文件id存放重写的数据，新的活跃文件使用预留id之后的id
+	// 重写的数据都是压缩开始时的有效数据，文件id比之后写入的数据小，重启时按文件id顺序加载即可保证正确性
+	firstFileId := db.activeFile.FileId + 1
+	fileNum := uint32(len(counter.fileIds))
+	if uint64(firstFileId)+uint64(fileNum) > math.MaxUint32 {
+		db.mu.Unlock()
+		return ErrCompactFileIdExhausted
+	}
+	maxFileId := firstFileId + fileNum - 1
+	oldFile := db.activeFile
+	db.olderFiles[oldFile.FileId] = oldFile
+	activeFile, err := db.openActiveDataFile(maxFileId + 1)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	db.activeFile = activeFile
+	db.fileRotated(oldFile)
+
+	// 删除标记只有在更旧的数据文件中可能存在对应的key时才需要保留
+	// merge生成的hint文件中可能包含任意的key，需要保留所有的删除标记
+	var minRetainedFileId = firstFileId
+	for fid := range db.olderFiles {
+		if fid < minRetainedFileId && !containsDataFile(compactFiles, fid) {
+			minRetainedFileId = fid
+		}
+	}
+	_, err = os.Stat(filepath.Join(db.options.DirPath, data.HintFileName))
+	hasMergeHint := err == nil
+	db.mu.Unlock()
+
+	writer := &compactWriter{
+		dirPath:    db.options.DirPath,
+		fileSize:   db.options.DataFileSize,
+		nextFileId: firstFileId,
+		maxFileId:  maxFileId,
+	}
+	entries, err := db.rewriteDataFiles(compactFiles, indexes, writer, func(fid uint32) bool {
+		return hasMergeHint || minRetainedFileId < fid
+	})
+	if err == nil {
+		err = writer.close()
+	}
+	if err != nil {
+		writer.abort()
+		return err
+	}
+
+	// 与索引快照互斥，避免正在生成的快照在被压缩的文件删除之后才完成重命名，引用已经删除的文件
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.swapCompactFiles(compactFiles, writer.fileIds, entries); err != nil {
+		return err
+	}
+	// 被压缩的文件已经删除，重新统计磁盘使用情况，等待中的写入可以继续
+	if db.diskQuota != nil {
+		return db.diskQuota.refresh()
+	}
+	return nil
+}
+
+// pickCompactFiles 按待回收数据的占比从高到低挑选旧数据文件，占比需要达到DataFileMergeRatio
+func (db *DB) pickCompactFiles(maxFiles int) ([]*data.DataFile, error) {
+	type candidate struct {
+		dataFile *data.DataFile
+		ratio    float64
+	}
+	var candidates []candidate
+	for fid, dataFile := range db.olderFiles {
+		db.garbageMu.Lock()
+		garbage := db.fileGarbage[fid]
+		db.garbageMu.Unlock()
+		if garbage <= 0 {
+			continue
+		}
+		size, err := dataFile.IOManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			continue
+		}
+		ratio := float64(garbage) / float64(size)
+		if ratio < float64(db.options.DataFileMergeRatio) {
+			continue
+		}
+		candidates = append(candidates, candidate{dataFile: dataFile, ratio: ratio})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].ratio != candidates[j].ratio {
+			return candidates[i].ratio > candidates[j].ratio
+		}
+		return candidates[i].dataFile.FileId < candidates[j].dataFile.FileId
+	})
+	if len(candidates) > maxFiles {
+		candidates = candidates[:maxFiles]
+	}
+
+	picked := make(map[uint32]bool, len(candidates))
+	for _, c := range candidates {
+		picked[c.dataFile.FileId] = true
+	}
+	// 重写之后不保留事务完成标识，事务完成标识所在的文件只能和事务之前的所有文件一起压缩
+	// 否则重启时之前文件中的事务数据找不到完成标识，已经提交的数据会被丢弃
+	for changed := true; changed; {
+		changed = false
+		for lastFid, firstFid := range db.txnSpans {
+			if !picked[lastFid] {
+				continue
+			}
+			for fid := firstFid; fid < lastFid; fid++ {
+				if _, ok := db.olderFiles[fid]; ok && !picked[fid] {
+					delete(picked, lastFid)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var compactFiles []*data.DataFile
+	for _, c := range candidates {
+		if picked[c.dataFile.FileId] {
+			compactFiles = append(compactFiles, c.dataFile)
+		}
+	}
+	// 按文件id从小到大重写，保证同一个key的删除标记与数据的先后顺序不变
+	sort.Slice(compactFiles, func(i, j int) bool {
+		return compactFiles[i].FileId < compactFiles[j].FileId
+	})
+	return compactFiles, nil
+}
+
+func containsDataFile(dataFiles []*data.DataFile, fid uint32) bool {
+	for _, dataFile := range dataFiles {
+		if dataFile.FileId == fid {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteDataFiles 将数据文件中的有效数据以及需要保留的删除标记写入到新的文件中，indexes为每个命名空间的索引
+// keepTombstone为nil时保留所有的删除标记，用于统计重写需要的文件数量，之后key被删除时对应的删除标记需要保留
+func (db *DB) rewriteDataFiles(compactFiles []*data.DataFile, indexes map[uint32]index.Indexer, writer *compactWriter,
+	keepTombstone func(fid uint32) bool) ([]*compactEntry, error) {
+	var entries []*compactEntry
+	for _, dataFile := range compactFiles {
+		var offset int64 = 0
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			nsId := splitNamespace(logRecord)
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			oldPos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: uint32(size)}
+			offset += size
+
+			// 命名空间已经被删除，其中的数据都可以丢弃
+			idx := indexes[nsId]
+			if idx == nil {
+				continue
+			}
+			switch logRecord.Type {
+			case data.LogRecordNormal, data.LogRecordMergeOperand:
+				// 和内存中索引位置进行比较，如果有效则重写
+				pos := db.indexGet(idx, realKey)
+				if pos == nil || pos.Fid != oldPos.Fid || pos.Offset != oldPos.Offset {
+					continue
+				}
+			case data.LogRecordDeleted:
+				// key已经重新写入，或者更旧的文件中不可能存在这个key，删除标记可以丢弃
+				if keepTombstone != nil && (!keepTombstone(dataFile.FileId) || db.indexGet(idx, realKey) != nil) {
+					continue
+				}
+			default:
+				// 事务完成的标识不需要保留，重写后的数据都不再属于事务
+				continue
+			}
+
+			if err := db.collapseMergeOperand(realKey, logRecord); err != nil {
+				return nil, err
+			}
+			// 清除事务标记
+			typ := logRecord.Type
+			logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+			joinNamespace(logRecord, nsId)
+			newPos, err := writer.write(logRecord, nsId, realKey)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &compactEntry{
+				nsId:   nsId,
+				key:    append([]byte(nil), realKey...),
+				oldPos: oldPos,
+				newPos: newPos,
+				typ:    typ,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// swapCompactFiles 用重写后的文件替换被压缩的文件，需要持有db.snapshotLock以及db.mu
+func (db *DB) swapCompactFiles(compactFiles []*data.DataFile, fileIds []uint32, entries []*compactEntry) error {
+	// 先重命名数据文件，再重命名hint文件，中途退出时重启可以直接读取数据文件
+	// 被压缩的文件删除之前，新旧文件中的数据是相同的，重复加载不影响正确性
+	for _, fid := range fileIds {
+		fileName := data.GetDataFileName(db.options.DirPath, fid)
+		if err := os.Rename(fileName+data.CompactFileSuffix, fileName); err != nil {
+			return err
+		}
+		hintFileName := data.GetDataHintFileName(db.options.DirPath, fid)
+		if err := os.Rename(hintFileName+data.CompactFileSuffix, hintFileName); err != nil {
+			return err
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fid, db.dataFileIOType())
+		if err != nil {
+			return err
+		}
+		db.olderFiles[fid] = dataFile
+	}
+
+	// 只更新压缩期间没有被重新写入或者删除的key，其余重写的数据已经失效
+	batches := db.newIndexBatches(nil)
+	for _, entry := range entries {
+		if entry.typ == data.LogRecordDeleted {
+			db.addReclaimSize(entry.newPos)
+			continue
+		}
+		// 压缩期间命名空间被删除
+		idx := db.namespaceIndex(entry.nsId)
+		if idx == nil {
+			db.addReclaimSize(entry.newPos)
+			continue
+		}
+		pos := idx.Get(entry.key)
+		if pos == nil || pos.Fid != entry.oldPos.Fid || pos.Offset != entry.oldPos.Offset {
+			db.addReclaimSize(entry.newPos)
+			continue
+		}
+		batches.add(entry.nsId, index.BatchOp{Key: entry.key, Pos: entry.newPos, Type: data.LogRecordNormal})
+	}
+	batches.flush()
+
+	// 删除被压缩的文件
+	for _, dataFile := range compactFiles {
+		db.garbageMu.Lock()
+		db.reclaimSize -= db.fileGarbage[dataFile.FileId]
+		delete(db.fileGarbage, dataFile.FileId)
+		db.garbageMu.Unlock()
+		delete(db.txnSpans, dataFile.FileId)
+		delete(db.olderFiles, dataFile.FileId)
+		if db.valueCache != nil {
+			db.valueCache.evictFile(dataFile.FileId)
+		}
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+		hintFileName := data.GetDataHintFileName(db.options.DirPath, dataFile.FileId)
+		if err := os.Remove(hintFileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(data.GetDataFileName(db.options.DirPath, dataFile.FileId)); err != nil {
+			return err
+		}
+	}
+
+	// 数据文件已经被重写，索引快照失效
+	return db.removeIndexSnapshot()
+}
+
+// write 写入一条记录，当前文件写满时切换到下一个预留的文件id，realKey为命名空间nsId中实际的key
+func (cw *compactWriter) write(logRecord *data.LogRecord, nsId uint32, realKey []byte) (*data.LogRecordPos, error) {
+	encRecord, size := data.EncodeLogRecord(logRecord)
+	if len(cw.fileIds) == 0 || cw.writeOff+size >= cw.fileSize {
+		if err := cw.rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	pos := &data.LogRecordPos{
+		Fid:    cw.fileIds[len(cw.fileIds)-1],
+		Offset: cw.writeOff,
+		Size:   uint32(size),
+	}
+	cw.writeOff += size
+	if cw.dryRun {
+		return pos, nil
+	}
+	if err := cw.dataFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	recordType := logRecord.Type &^ data.LogRecordNamespaceFlag
+	if err := cw.hintFile.Write(encodeNamespaceHintRecord(nsId, realKey, pos, recordType)); err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+func (cw *compactWriter) rotate() error {
+	if cw.dataFile != nil {
+		if err := cw.close(); err != nil {
+			return err
+		}
+	}
+	if cw.nextFileId > cw.maxFileId {
+		return ErrCompactFileIdExhausted
+	}
+
+	fid := cw.nextFileId
+	if !cw.dryRun {
+		dataFile, err := data.OpenCompactFile(data.GetDataFileName(cw.dirPath, fid), fid)
+		if err != nil {
+			return err
+		}
+		hintFile, err := data.OpenCompactFile(data.GetDataHintFileName(cw.dirPath, fid), fid)
+		if err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+		cw.dataFile, cw.hintFile = dataFile, hintFile
+	}
+	cw.fileIds = append(cw.fileIds, fid)
+	cw.nextFileId++
+	cw.writeOff = 0
+	return nil
+}
+
+// close 持久化并关闭当前正在写入的文件
+func (cw *compactWriter) close() error {
+	if cw.dataFile == nil {
+		return nil
+	}
+	for _, file := range []*data.DataFile{cw.dataFile, cw.hintFile} {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	cw.dataFile, cw.hintFile = nil, nil
+	return nil
+}
+
+// abort 压缩失败时删除已经生成的临时文件
+func (cw *compactWriter) abort() {
+	if cw.dataFile != nil {
+		_ = cw.dataFile.Close()
+		_ = cw.hintFile.Close()
+	}
+	for _, fid := range cw.fileIds {
+		_ = os.Remove(data.GetDataFileName(cw.dirPath, fid) + data.CompactFileSuffix)
+		_ = os.Remove(data.GetDataHintFileName(cw.dirPath, fid) + data.CompactFileSuffix)
+	}
+}
@@ -0,0 +1,324 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_CompactFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	// 第一批key只写入一次，后面的文件中删除其中一部分，删除标记需要保留
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	// 反复覆盖写入，前面的文件中产生大量待回收的数据
+	for n := 0; n < 5; n++ {
+		for i := 1000; i < 1300; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d-%d", i, n))))
+		}
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 1000; i < 1300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+
+	err = db.CompactFiles(0)
+	assert.NotNil(t, err)
+
+	fileNum, reclaimSize := len(db.olderFiles), db.reclaimSize
+	var garbageFid uint32
+	for fid, size := range db.fileGarbage {
+		if size > db.fileGarbage[garbageFid] {
+			garbageFid = fid
+		}
+	}
+	assert.Nil(t, db.CompactFiles(2))
+	assert.Less(t, db.reclaimSize, reclaimSize)
+	assert.Nil(t, db.olderFiles[garbageFid])
+	_, err = os.Stat(data.GetDataFileName(dir, garbageFid))
+	assert.True(t, os.IsNotExist(err))
+	assert.LessOrEqual(t, len(db.olderFiles), fileNum+1)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 1300; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if (i >= 200 && i < 1000) || i < 50 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		values[string(utils.GetTestKey(i))] = val
+	}
+
+	// 继续压缩直到没有可以回收的文件
+	for {
+		if err := db.CompactFiles(2); err == ErrMergeRatioUnreached {
+			break
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.Nil(t, db.Put([]byte("after-compact"), []byte("v")))
+	assert.Nil(t, db.Close())
+
+	// 重启后从hint文件加载，数据与压缩前一致
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values)+1, len(db.ListKeys()))
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	for i := 0; i < 50; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_CompactFilesConcurrentWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for n := 0; n < 10; n++ {
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", n))))
+		}
+	}
+
+	// 压缩期间写入的数据不会被重写的数据覆盖
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("latest")))
+			if i%10 == 0 {
+				assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			}
+		}
+	}()
+	assert.Nil(t, db.CompactFiles(4))
+	wg.Wait()
+
+	check := func(db *DB) {
+		for i := 0; i < 100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if i%10 == 0 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("latest"), val)
+			}
+		}
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_CompactFilesTxnSpan(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-txn")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0.3
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	// 批量写入跨越了数据文件，事务完成标识和之前的数据不在同一个文件中
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 40; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(100)))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, 1, len(db.txnSpans))
+	var firstFid, lastFid uint32
+	for lastFid, firstFid = range db.txnSpans {
+	}
+	assert.Less(t, firstFid, lastFid)
+
+	// 事务完成标识所在的文件中产生大量待回收的数据
+	for n := 0; n < 3; n++ {
+		for i := 1000; i < 1020; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(100)))
+		}
+	}
+	for {
+		if err := db.CompactFiles(1); err == ErrMergeRatioUnreached {
+			break
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	assert.NotNil(t, db.olderFiles[lastFid])
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, firstFid, db.txnSpans[lastFid])
+	for i := 0; i < 40; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 事务之前的文件同样可以回收时一起压缩
+	for i := 0; i < 40; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	assert.Nil(t, db.CompactFiles(100))
+	assert.Nil(t, db.olderFiles[firstFid])
+	assert.Nil(t, db.olderFiles[lastFid])
+	assert.Equal(t, 0, len(db.txnSpans))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 40; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(val))
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_CompactFilesConcurrentSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-snapshot")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 压缩期间不断生成索引快照，重启时不能加载引用已删除文件的快照
+	stop := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				assert.Nil(t, db.Put([]byte("snapshot"), utils.RandomValue(64)))
+				assert.Nil(t, db.SnapshotIndex())
+			}
+		}
+	}()
+	for n := 0; n < 30; n++ {
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", n))))
+		}
+		if err := db.CompactFiles(4); err != nil {
+			assert.Equal(t, ErrMergeRatioUnreached, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value-29"), val)
+	}
+	assert.Nil(t, db.Close())
+}
+
+// 每个操作数重复16次追加到已有的值之后，合并之后的值比操作数记录大得多
+type expandOperator struct{}
+
+func (expandOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	value := append([]byte(nil), existing...)
+	for _, operand := range operands {
+		value = append(value, bytes.Repeat(operand, 16)...)
+	}
+	return value, nil
+}
+
+func TestDB_CompactFilesOperandGrowth(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 4096
+	opts.DataFileMergeRatio = 0
+	opts.MergeOperator = expandOperator{}
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for n := 0; n < 6; n++ {
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, db.MergeValue(utils.GetTestKey(i), []byte(fmt.Sprintf("operand-%02d-%05d", n, i))))
+		}
+		assert.Nil(t, db.Put([]byte("garbage"), utils.RandomValue(16)))
+	}
+	// 写满活跃文件，操作数链所在的文件都成为旧的数据文件
+	for len(db.olderFiles) < 3 {
+		assert.Nil(t, db.Put([]byte("filler"), utils.RandomValue(512)))
+	}
+
+	values := make(map[int][]byte)
+	var total int64
+	for i := 0; i < 10; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		values[i] = val
+		total += int64(len(val))
+	}
+	// 合并之后的值超过了被压缩文件的总大小
+	var inputSize int64
+	for _, dataFile := range db.olderFiles {
+		size, err := dataFile.IOManager.Size()
+		assert.Nil(t, err)
+		inputSize += size
+	}
+	assert.Greater(t, total, inputSize)
+
+	assert.Nil(t, db.CompactFiles(100))
+	check := func(db *DB) {
+		for i := 0; i < 10; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Close())
+}
@@ -7,17 +7,39 @@ import (
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync"
 )
 
 var (
 	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
 )
 
+// 读取记录时复用的缓冲区，超过maxPooledRecordBufSize的缓冲区不放回，避免长期占用内存
+const maxPooledRecordBufSize = 64 * 1024
+
+var recordBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+func putRecordBuf(bufp *[]byte) {
+	if cap(*bufp) > maxPooledRecordBufSize {
+		return
+	}
+	recordBufPool.Put(bufp)
+}
+
 const (
 	DataFileNameSuffix    = ".data"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	IndexSnapshotFileName = "index-snapshot"
+	DataHintFileSuffix    = ".hint"
+	CompactFileSuffix     = ".compact"
+	NamespaceFileName     = "namespaces"
 )
 
 // DataFile 数据文件
@@ -33,6 +55,24 @@ func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	return newDataFile(fileName, fileId, ioType)
 }
 
+// OpenBufferedDataFile 打开带写缓冲的数据文件，用于活跃文件，bufferSize为缓冲区大小
+func OpenBufferedDataFile(dirPath string, fileId uint32, bufferSize int) (*DataFile, error) {
+	ioManager, err := fio.NewBufferedIOManager(GetDataFileName(dirPath, fileId), bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DataFile{FileId: fileId, IOManager: ioManager}, nil
+}
+
+// OpenDirectDataFile 打开使用O_DIRECT写入的数据文件，用于merge输出，只在Linux上生效
+func OpenDirectDataFile(dirPath string, fileId uint32, bufferSize int) (*DataFile, error) {
+	ioManager, err := fio.NewDirectIOManager(GetDataFileName(dirPath, fileId), bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DataFile{FileId: fileId, IOManager: ioManager}, nil
+}
+
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
@@ -43,12 +83,43 @@ func OpenHintFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// GetDataHintFileName 单个数据文件对应的hint文件，由文件级别的压缩生成
+func GetDataHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataHintFileSuffix)
+}
+
+// OpenCompactFile 打开压缩时写入的临时文件，fileName为数据文件或者hint文件的文件名
+func OpenCompactFile(fileName string, fileId uint32) (*DataFile, error) {
+	return newDataFile(fileName+CompactFileSuffix, fileId, fio.StandardFIO)
+}
+
+// OpenDataHintFile 以只读方式打开单个数据文件对应的hint文件
+func OpenDataHintFile(dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(GetDataHintFileName(dirPath, fileId), fileId, fio.ReadOnlyFIO)
+}
+
+// OpenReadOnlyFile 以只读方式打开已经存在的文件，用于加载时只需要读取的hint文件、命名空间文件等
+func OpenReadOnlyFile(fileName string) (*DataFile, error) {
+	return newDataFile(fileName, 0, fio.ReadOnlyFIO)
+}
+
 // OpenMergeFinishedFile 打开标识merge完成的文件
 func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenIndexSnapshotFile 以只读方式打开索引快照文件
+func OpenIndexSnapshotFile(dirPath string, fileName string) (*DataFile, error) {
+	return newDataFile(filepath.Join(dirPath, fileName), 0, fio.ReadOnlyFIO)
+}
+
+// OpenNamespaceFile 打开记录命名空间创建和删除的文件
+func OpenNamespaceFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, NamespaceFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
 func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
 	return newDataFile(fileName, 0, fio.StandardFIO)
@@ -78,12 +149,23 @@ func (df *DataFile) Write(buf []byte) error {
 }
 
 func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
+	return df.Write(EncodeHintRecord(key, pos))
+}
+
+// EncodeHintRecord 编码hint记录，key为实际的key，value为位置信息
+func EncodeHintRecord(key []byte, pos *LogRecordPos) []byte {
+	return EncodeHintRecordWithType(key, pos, LogRecordNormal)
+}
+
+// EncodeHintRecordWithType 编码hint记录，recordType为LogRecordDeleted时表示pos位置是删除标记
+func EncodeHintRecordWithType(key []byte, pos *LogRecordPos, recordType LogRecordType) []byte {
 	record := &LogRecord{
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
+		Type:  recordType,
 	}
 	encRecord, _ := EncodeLogRecord(record)
-	return df.Write(encRecord)
+	return encRecord
 }
 
 // Sync 同步数据
@@ -152,6 +234,99 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	return logRecord, recordSize, nil
 }
 
+// ReadLogRecordAt 根据索引中记录的大小，一次读取offset位置的完整记录，并从同一个缓冲区中解码header以及key value
+// 与ReadLogRecord相比，不需要获取文件大小，也不需要分两次读取；使用复用的缓冲区读取，只为key和value分配一次内存
+func (df *DataFile) ReadLogRecordAt(offset int64, size uint32) (*LogRecord, error) {
+	bufp := recordBufPool.Get().(*[]byte)
+	defer putRecordBuf(bufp)
+	logRecord, err := df.readPooledLogRecord(bufp, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	keySize := len(logRecord.Key)
+	kv := make([]byte, 0, keySize+len(logRecord.Value))
+	kv = append(kv, logRecord.Key...)
+	kv = append(kv, logRecord.Value...)
+	logRecord.Key = kv[:keySize:keySize]
+	logRecord.Value = kv[keySize:]
+	return logRecord, nil
+}
+
+// ReadLogRecordsAt 批量读取多条完整的记录，offsets与sizes一一对应，IO支持批量读取时一次提交所有读取
+func (df *DataFile) ReadLogRecordsAt(offsets []int64, sizes []uint32) ([]*LogRecord, []error) {
+	logRecords := make([]*LogRecord, len(offsets))
+	errs := make([]error, len(offsets))
+	batchReader, ok := df.IOManager.(fio.BatchReader)
+	if !ok {
+		for i := range offsets {
+			logRecords[i], errs[i] = df.ReadLogRecordAt(offsets[i], sizes[i])
+		}
+		return logRecords, errs
+	}
+
+	reqs := make([]fio.ReadRequest, len(offsets))
+	for i := range reqs {
+		reqs[i] = fio.ReadRequest{Buf: make([]byte, sizes[i]), Offset: offsets[i]}
+	}
+	batchReader.ReadBatch(reqs)
+	for i, req := range reqs {
+		if req.Err != nil {
+			errs[i] = req.Err
+			continue
+		}
+		logRecords[i], errs[i] = decodeLogRecord(req.Buf)
+	}
+	return logRecords, errs
+}
+
+// ReadLogRecordKeyAt 读取offset位置的记录，只返回key以及类型，value为nil
+// 使用复用的缓冲区读取，只需要为key分配内存
+func (df *DataFile) ReadLogRecordKeyAt(offset int64, size uint32) (*LogRecord, error) {
+	bufp := recordBufPool.Get().(*[]byte)
+	defer putRecordBuf(bufp)
+	logRecord, err := df.readPooledLogRecord(bufp, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	logRecord.Key = append([]byte(nil), logRecord.Key...)
+	logRecord.Value = nil
+	return logRecord, nil
+}
+
+// readPooledLogRecord 使用bufp中的缓冲区读取并解码记录，key和value引用缓冲区中的内存，放回缓冲区之前需要拷贝
+func (df *DataFile) readPooledLogRecord(bufp *[]byte, offset int64, size uint32) (*LogRecord, error) {
+	if int(size) > cap(*bufp) {
+		*bufp = make([]byte, size)
+	}
+	buf := (*bufp)[:size]
+	if _, err := df.IOManager.Read(buf, offset); err != nil {
+		return nil, err
+	}
+	return decodeLogRecord(buf)
+}
+
+// decodeLogRecord 解码一条完整的记录，key和value引用buf中的内存
+func decodeLogRecord(buf []byte) (*LogRecord, error) {
+	if len(buf) <= crc32.Size {
+		return nil, ErrInvalidCRC
+	}
+	header, headerSize := decodeLogRecordHeader(buf)
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	if headerSize+keySize+valueSize != int64(len(buf)) {
+		return nil, ErrInvalidCRC
+	}
+
+	// CRC校验数据的完整性
+	if crc32.ChecksumIEEE(buf[crc32.Size:]) != header.crc {
+		return nil, ErrInvalidCRC
+	}
+	return &LogRecord{
+		Key:   buf[headerSize : headerSize+keySize],
+		Value: buf[headerSize+keySize:],
+		Type:  header.recordType,
+	}, nil
+}
+
 func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 	err := df.IOManager.Close()
 	if err != nil {
@@ -165,6 +340,35 @@ func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 	return nil
 }
 
+// Advise 提示操作系统文件的访问模式，IO不支持时忽略
+func (df *DataFile) Advise(advice fio.FileAdvice) error {
+	if advisor, ok := df.IOManager.(fio.Advisor); ok {
+		return advisor.Advise(advice)
+	}
+	return nil
+}
+
+// SetPreallocate 写入时按chunkSize预分配磁盘空间，IO不支持时忽略
+func (df *DataFile) SetPreallocate(chunkSize int64) error {
+	if preallocator, ok := df.IOManager.(fio.Preallocator); ok {
+		return preallocator.SetPreallocate(chunkSize)
+	}
+	return nil
+}
+
+// SetBufferedIOManager 切换为带写缓冲的IO，用于启动之后的活跃文件
+func (df *DataFile) SetBufferedIOManager(dirPath string, bufferSize int) error {
+	if err := df.IOManager.Close(); err != nil {
+		return err
+	}
+	ioManager, err := fio.NewBufferedIOManager(GetDataFileName(dirPath, df.FileId), bufferSize)
+	if err != nil {
+		return err
+	}
+	df.IOManager = ioManager
+	return nil
+}
+
 func (df *DataFile) readNBytes(n int64, offset int64) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := df.IOManager.Read(b, offset)
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func writeTestRecords(t testing.TB, dataFile *DataFile, n int) []*LogRecordPos {
+	positions := make([]*LogRecordPos, n)
+	for i := 0; i < n; i++ {
+		record := &LogRecord{
+			Key:   []byte(fmt.Sprintf("key-%09d", i)),
+			Value: []byte(fmt.Sprintf("value-%0128d", i)),
+		}
+		if i%10 == 0 {
+			record.Type = LogRecordDeleted
+			record.Value = nil
+		}
+		encRecord, size := EncodeLogRecord(record)
+		positions[i] = &LogRecordPos{Fid: dataFile.FileId, Offset: dataFile.WriteOff, Size: uint32(size)}
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	return positions
+}
+
+func TestDataFile_ReadLogRecordAt(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	positions := writeTestRecords(t, dataFile, 100)
+	for _, pos := range positions {
+		expected, size, err := dataFile.ReadLogRecord(pos.Offset)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(pos.Size), size)
+
+		record, err := dataFile.ReadLogRecordAt(pos.Offset, pos.Size)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Key, record.Key)
+		assert.Equal(t, expected.Type, record.Type)
+		assert.Equal(t, len(expected.Value), len(record.Value))
+		assert.Equal(t, string(expected.Value), string(record.Value))
+
+		keyRecord, err := dataFile.ReadLogRecordKeyAt(pos.Offset, pos.Size)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Key, keyRecord.Key)
+		assert.Nil(t, keyRecord.Value)
+	}
+
+	// 读取的缓冲区会被复用，返回的key和value不受之后读取的影响
+	first, err := dataFile.ReadLogRecordAt(positions[1].Offset, positions[1].Size)
+	assert.Nil(t, err)
+	for _, pos := range positions[2:] {
+		_, err := dataFile.ReadLogRecordAt(pos.Offset, pos.Size)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, "key-000000001", string(first.Key))
+	assert.Equal(t, fmt.Sprintf("value-%0128d", 1), string(first.Value))
+	first.Key = append(first.Key, 'x')
+	assert.Equal(t, fmt.Sprintf("value-%0128d", 1), string(first.Value))
+
+	// 大小与记录不一致时返回错误
+	_, err = dataFile.ReadLogRecordAt(positions[1].Offset, positions[1].Size-1)
+	assert.Equal(t, ErrInvalidCRC, err)
+	_, err = dataFile.ReadLogRecordAt(positions[1].Offset, positions[1].Size+positions[2].Size)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
+
+func benchmarkReadLogRecord(b *testing.B, read func(dataFile *DataFile, pos *LogRecordPos) error) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-bench")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(b, err)
+	defer dataFile.Close()
+	positions := writeTestRecords(b, dataFile, 10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := read(dataFile, positions[i%len(positions)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDataFile_ReadLogRecord(b *testing.B) {
+	benchmarkReadLogRecord(b, func(dataFile *DataFile, pos *LogRecordPos) error {
+		_, _, err := dataFile.ReadLogRecord(pos.Offset)
+		return err
+	})
+}
+
+func BenchmarkDataFile_ReadLogRecordAt(b *testing.B) {
+	benchmarkReadLogRecord(b, func(dataFile *DataFile, pos *LogRecordPos) error {
+		_, err := dataFile.ReadLogRecordAt(pos.Offset, pos.Size)
+		return err
+	})
+}
+
+func BenchmarkDataFile_ReadLogRecordKeyAt(b *testing.B) {
+	benchmarkReadLogRecord(b, func(dataFile *DataFile, pos *LogRecordPos) error {
+		_, err := dataFile.ReadLogRecordKeyAt(pos.Offset, pos.Size)
+		return err
+	})
+}
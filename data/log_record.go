@@ -11,8 +11,13 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// LogRecordMergeOperand 合并操作数，需要和之前的值一起通过MergeOperator合并得到完整的值
+	LogRecordMergeOperand
 )
 
+// LogRecordNamespaceFlag 记录属于非默认的命名空间，key的最前面是变长编码的命名空间id
+const LogRecordNamespaceFlag LogRecordType = 0x80
+
 // crc type keySize valueSize
 // 4 +  1  +  5   +   5 = 15
 const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
@@ -106,8 +111,13 @@ func DecodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	if len(buf) < 4 {
 		return nil, 0
 	}
+	header, headerSize := decodeLogRecordHeader(buf)
+	return &header, headerSize
+}
 
-	header := &LogRecordHeader{
+// decodeLogRecordHeader 解码header，不在堆上分配内存，buf的长度至少为5
+func decodeLogRecordHeader(buf []byte) (LogRecordHeader, int64) {
+	header := LogRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
 		recordType: buf[4],
 	}
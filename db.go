@@ -5,6 +5,8 @@ import (
 	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
@@ -15,11 +17,15 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	seqNoKey     = "seq.no"
 	fileLockName = "flock"
+	// 启动加载索引时每批更新的数量
+	indexBatchSize = 1024
 )
 
 type DB struct {
@@ -28,22 +34,47 @@ type DB struct {
 	activeFile      *data.DataFile            // 当前活跃数据文件，可以用于写入
 	olderFiles      map[uint32]*data.DataFile // 旧的数据文件，只能用于读取
 	index           index.Indexer
-	fileIds         []int        // 文件id，只能在加载索引的时候使用
-	seqNo           uint64       // 事务序列号，全局递增
-	isMerge         bool         // 是否正在合并
-	seqNoFileExists bool         // 存储事务序列号的文件是否存在
-	isInitial       bool         // 是否是第一次初始化此数据目录
-	fileLock        *flock.Flock // 文件锁保证多进程之间的互斥
-	bytesWrite      uint         // 累计写了多少字节
-	reclaimSize     int64        // 有多少字节待回收
+	fileIds         []int                 // 文件id，只能在加载索引的时候使用
+	seqNo           uint64                // 事务序列号，全局递增
+	isMerge         bool                  // 是否正在合并
+	seqNoFileExists bool                  // 存储事务序列号的文件是否存在
+	isInitial       bool                  // 是否是第一次初始化此数据目录
+	fileLock        *flock.Flock          // 文件锁保证多进程之间的互斥
+	bytesWrite      uint                  // 累计写了多少字节
+	garbageMu       sync.Mutex            // 保护reclaimSize以及fileGarbage，分片索引在db.mu之外更新时也会修改它们
+	reclaimSize     int64                 // 有多少字节待回收
+	fileGarbage     map[uint32]int64      // 每个数据文件中有多少字节待回收
+	txnSpans        map[uint32]uint32     // 跨越多个数据文件的事务，key为事务完成标识所在的文件id，value为事务第一条记录所在的文件id
+	snapshotLock    *sync.Mutex           // 保证同一时间只有一个索引快照在生成
+	lastSnapshot    *indexWatermark       // 最近一次索引快照的水位线
+	closeCh         chan struct{}         // 关闭数据库时通知后台任务退出
+	closeOnce       sync.Once             // 保证重复调用Close时只关闭一次
+	bgWait          sync.WaitGroup        // 等待后台任务退出
+	mergeDone       atomic.Int64          // 正在进行的merge已经处理的字节数
+	mergeTotal      atomic.Int64          // 正在进行的merge需要处理的总字节数
+	namespaces      map[string]*Namespace // 命名空间，按名称查找
+	namespaceIds    map[uint32]*Namespace // 命名空间，按id查找
+	nextNamespaceId uint32                // 下一个命名空间id，删除之后的id不再使用
+	namespaceFile   *data.DataFile        // 记录命名空间创建和删除的文件，第一次写入时打开
+	// 二级索引，只包含默认命名空间中的数据
+	secondaryIndexes map[string]*secondaryIndex
+	valueCache       *valueCache // 读取记录的缓存，没有开启时为nil
+	directWrites     bool        // 活跃文件使用O_DIRECT写入，只用于merge时的临时实例
+	diskQuota        *diskQuota  // 磁盘限制，没有开启时为nil
+	logger           Logger      // 没有配置Logger时不输出日志
 }
 
 // Stat 数据库状态
 type Stat struct {
-	keyNum          uint   // key数量
+	KeyNum          uint   // key数量
 	DataFileNum     uint   // 数据文件数量
 	ReclaimableSize int64  // 可以进行merge回收的数据量，字节为单位
 	DiskSize        uint64 // 数据目录所占磁盘空间大小
+	MergeDone       int64  // 正在进行的merge已经处理的字节数
+	MergeTotal      int64  // 正在进行的merge需要处理的总字节数，没有进行merge时为0
+	CacheHits       uint64 // 读取记录时命中缓存的次数
+	CacheMisses     uint64 // 读取记录时没有命中缓存的次数
+	CacheSize       int64  // 缓存当前占用的字节数
 }
 
 // Open 打开数据库实例
@@ -52,18 +83,36 @@ func Open(options Options) (*DB, error) {
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
+	start := time.Now()
 	var isInitial bool
 	// 判断数据目录是否存在，不存在则创建数据目录
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		// 只读时不创建数据目录
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
-	// 判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	// 判断当前数据目录是否正在使用，只读实例之间使用共享锁
+	lockFileName := filepath.Join(options.DirPath, fileLockName)
+	var fileLock *flock.Flock
+	var hold = true
+	var err error
+	if !options.ReadOnly {
+		fileLock = flock.New(lockFileName)
+		hold, err = fileLock.TryLock()
+	} else if _, err = os.Stat(lockFileName); err == nil {
+		// 只读时不创建文件锁，以只读方式打开已有的文件锁
+		fileLock = flock.New(lockFileName, flock.SetFlag(os.O_RDONLY))
+		hold, err = fileLock.TryRLock()
+	} else if os.IsNotExist(err) {
+		// 文件锁不存在说明数据目录没有被可写实例打开过，例如备份生成的目录，不需要加锁
+		err = nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -75,22 +124,46 @@ func Open(options Options) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(entries) == 0 {
+	// 文件锁在此之前已经创建，只有文件锁时同样是新的数据目录
+	if len(entries) == 0 || (len(entries) == 1 && entries[0].Name() == fileLockName) {
 		isInitial = true
 	}
 
 	// 初始化DB实例结构体
 	db := &DB{
-		options:    options,
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		mu:         new(sync.RWMutex),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+		options:      options,
+		olderFiles:   make(map[uint32]*data.DataFile),
+		fileGarbage:  make(map[uint32]int64),
+		txnSpans:     make(map[uint32]uint32),
+		mu:           new(sync.RWMutex),
+		isInitial:    isInitial,
+		fileLock:     fileLock,
+		snapshotLock: new(sync.Mutex),
+		closeCh:      make(chan struct{}),
+		namespaces:   make(map[string]*Namespace),
+		namespaceIds: make(map[uint32]*Namespace),
+
+		secondaryIndexes: make(map[string]*secondaryIndex),
+		logger:           options.Logger,
+	}
+	if db.logger == nil {
+		db.logger = nopLogger{}
+	}
+	if options.ValueCacheSize > 0 {
+		db.valueCache = newValueCache(options.ValueCacheSize)
+	}
+	// 加载merge数据目录，B+树索引文件可能被替换，需要在打开索引之前完成
+	merged, err := db.loadMergeFiles()
+	if err != nil {
+		return nil, err
 	}
 
-	// 加载merge数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	if db.index, err = newIndexer(options, db.readKeyByPosition); err != nil {
+		return nil, err
+	}
+
+	// 加载命名空间，数据文件中的记录需要更新到对应命名空间的索引中
+	if err := db.loadNamespaces(); err != nil {
 		return nil, err
 	}
 
@@ -100,13 +173,21 @@ func Open(options Options) (*DB, error) {
 	}
 
 	if options.IndexType != BPlusTree {
-		// 从hint索引文件中加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
+		// 优先从索引快照中加载，只需要回放快照之后写入的数据
+		watermark, err := db.loadIndexFromSnapshot()
+		if err != nil {
 			return nil, err
 		}
 
+		// 从hint索引文件中加载索引
+		if watermark == nil {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return nil, err
+			}
+		}
+
 		// 从数据文件中加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
+		if err := db.loadIndexFromDataFiles(watermark); err != nil {
 			return nil, err
 		}
 	}
@@ -120,6 +201,12 @@ func Open(options Options) (*DB, error) {
 
 	// 当索引结构为B+树时，需要取出当前事务序列号
 	if options.IndexType == BPlusTree {
+		// merge之后的B+树索引只包含参与merge的数据，需要加载merge期间写入的数据
+		if merged {
+			if err := db.loadIndexFromDataFiles(nil); err != nil {
+				return nil, err
+			}
+		}
 		if err := db.loadSeqNo(); err != nil {
 			return nil, err
 		}
@@ -132,34 +219,100 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 索引加载完成之后才删除merge目录，中途崩溃时重启会重新加载
+	if merged {
+		if err := os.RemoveAll(db.getMergePath()); err != nil {
+			return nil, err
+		}
+	}
+
+	// 开启了磁盘限制时统计数据目录当前的使用情况
+	if options.MaxDiskBytes > 0 || options.LowDiskWatermark > 0 {
+		db.diskQuota = newDiskQuota(options)
+		if err := db.diskQuota.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 后台定期生成索引快照
+	if options.IndexSnapshotInterval > 0 && options.IndexType != BPlusTree && !options.ReadOnly {
+		db.startIndexSnapshot()
+	}
+
+	db.logger.Info("database opened", "dir", options.DirPath, "dataFiles", len(db.fileIds),
+		"keys", db.index.Size(), "merged", merged, "duration", time.Since(start))
 	return db, nil
 }
 
-// Close 关闭数据库
+// Close 关闭数据库，重复调用时直接返回nil
 func (db *DB) Close() error {
+	var err error
+	db.closeOnce.Do(func() {
+		err = db.close()
+		if err != nil {
+			db.logger.Error("close database failed", "dir", db.options.DirPath, "err", err)
+		} else {
+			db.logger.Info("database closed", "dir", db.options.DirPath)
+		}
+		if fn := db.options.EventListener.OnClose; fn != nil {
+			fn(err)
+		}
+	})
+	return err
+}
+
+// close 关闭数据库，中间的步骤出错时仍然关闭所有的数据文件，返回第一个错误
+func (db *DB) close() error {
 	defer func() {
+		if db.fileLock == nil {
+			return
+		}
 		err := db.fileLock.Unlock()
 		if err != nil {
 			panic(fmt.Sprintf("fail to unlock the directory, %v", err))
 		}
 	}()
 
+	// 通知后台任务退出
+	close(db.closeCh)
+	db.bgWait.Wait()
+
+	firstErr := db.closeNamespaces()
 	if db.activeFile == nil {
-		return nil
+		return firstErr
 	}
+	setErr := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// 开启了索引快照时，关闭前生成最新的快照，加快下次启动
+	if db.options.IndexSnapshotInterval > 0 && !db.options.ReadOnly {
+		setErr(db.SnapshotIndex())
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	// 关闭索引
-	if err := db.index.Close(); err != nil {
-		return err
+	setErr(db.index.Close())
+
+	// 保存当前事务序列号，只读时没有新的事务
+	if !db.options.ReadOnly {
+		setErr(db.saveSeqNo())
 	}
+	setErr(db.closeDataFiles())
+	return firstErr
+}
 
-	// 保存当前事务序列号
+// saveSeqNo 保存当前事务序列号，下次启动时读取
+func (db *DB) saveSeqNo() error {
 	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	defer seqNoFile.Close()
 	record := &data.LogRecord{
 		Key:   []byte(seqNoKey),
 		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
@@ -169,23 +322,22 @@ func (db *DB) Close() error {
 	if err := seqNoFile.Write(encRecord); err != nil {
 		return err
 	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
-	}
+	return seqNoFile.Sync()
+}
 
+// closeDataFiles 关闭所有数据文件，某个文件关闭失败时继续关闭其余的文件，返回第一个错误
+func (db *DB) closeDataFiles() error {
 	// 关闭当前活跃文件
-	if err := db.activeFile.Close(); err != nil {
-		return err
-	}
+	firstErr := db.activeFile.Close()
 
 	// 关闭旧的数据文件
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.Close(); err != nil {
-			return err
+		if err := dataFile.Close(); err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
 
-	return nil
+	return firstErr
 }
 
 // Sync 持久化数据文件
@@ -196,7 +348,7 @@ func (db *DB) Sync() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	return db.activeFile.Sync()
+	return db.syncActiveFile()
 }
 
 func (db *DB) Stat() *Stat {
@@ -208,28 +360,68 @@ func (db *DB) Stat() *Stat {
 		dataFileSizes++
 	}
 
-	diskSize, err := utils.AvailableDiskSize()
+	// 获取磁盘使用情况失败时DiskSize为0，不影响其他状态
+	diskSize, err := utils.DirDiskUsage(db.options.DirPath)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size: %v", err))
+		db.logger.Warn("get dir disk usage failed", "dir", db.options.DirPath, "err", err)
 	}
 
-	return &Stat{
-		keyNum:          uint(db.index.Size()),
+	db.garbageMu.Lock()
+	reclaimSize := db.reclaimSize
+	db.garbageMu.Unlock()
+	stat := &Stat{
+		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFileSizes,
-		ReclaimableSize: db.reclaimSize,
+		ReclaimableSize: reclaimSize,
 		DiskSize:        diskSize,
+		MergeDone:       db.mergeDone.Load(),
+		MergeTotal:      db.mergeTotal.Load(),
+	}
+	if db.valueCache != nil {
+		stat.CacheHits = db.valueCache.hits.Load()
+		stat.CacheMisses = db.valueCache.misses.Load()
+		stat.CacheSize = db.valueCache.size()
 	}
+	return stat
 }
 
 // Backup 备份数据库 将数据文件拷贝到新的目录中
 func (db *DB) Backup(dir string) error {
+	start := time.Now()
+	err := db.backup(dir)
+	info := BackupInfo{Dir: dir, Duration: time.Since(start), Err: err}
+	if err != nil {
+		db.logger.Error("backup failed", "dir", dir, "err", err)
+	} else {
+		db.logger.Info("backup done", "dir", dir, "duration", info.Duration)
+	}
+	if fn := db.options.EventListener.OnBackupDone; fn != nil {
+		fn(info)
+	}
+	return err
+}
+
+func (db *DB) backup(dir string) error {
+	// 写缓冲中的数据需要先写入文件
+	if db.options.WriteBufferSize > 0 {
+		db.mu.Lock()
+		if db.activeFile != nil {
+			if err := db.syncActiveFile(); err != nil {
+				db.mu.Unlock()
+				return err
+			}
+		}
+		db.mu.Unlock()
+	}
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
 }
 
-func (db *DB) loadIndexFromDataFiles() error {
+// loadIndexFromDataFiles 从数据文件中加载索引，watermark不为空时只加载快照水位线之后的数据
+func (db *DB) loadIndexFromDataFiles(watermark *indexWatermark) error {
 	// 如果没有文件id，说明数据库是空的，直接返回
 	if len(db.fileIds) == 0 {
 		return nil
@@ -247,24 +439,24 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
-		} else {
-			oldPos = db.index.Put(key, pos)
+	// 批量更新索引，B+树索引可以在一个事务中完成多个key的更新
+	batches := db.newIndexBatches(func(op index.BatchOp, oldPos *data.LogRecordPos) {
+		if op.Type == data.LogRecordDeleted {
+			db.addReclaimSize(op.Pos)
 		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+		db.addReclaimSize(oldPos)
+	})
+	updateIndex := func(scanned *scannedRecord) {
+		op := index.BatchOp{Key: scanned.record.Key, Pos: scanned.pos, Type: scanned.record.Type}
+		// 命名空间已经被删除，其中的数据都可以回收
+		if !batches.add(scanned.nsId, op) {
+			db.addReclaimSize(scanned.pos)
 		}
 	}
 
-	// 暂存所有事务数据
-	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
-
-	// 遍历所有文件id，处理文件中的记录
+	// 收集需要加载的数据文件以及每个文件开始读取的位置
+	var loadFiles []*data.DataFile
+	var loadOffsets []int64
 	for _, fileId := range db.fileIds {
 		var fileId = uint32(fileId)
 
@@ -273,71 +465,241 @@ func (db *DB) loadIndexFromDataFiles() error {
 			continue
 		}
 
-		var dataFile *data.DataFile
+		// 快照中已经包含了水位线之前的数据
+		var offset int64 = 0
+		if watermark != nil {
+			if fileId < watermark.fid {
+				continue
+			}
+			if fileId == watermark.fid {
+				offset = watermark.offset
+			}
+		}
 
 		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
+			loadFiles = append(loadFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFiles[fileId]
+			loadFiles = append(loadFiles, db.olderFiles[fileId])
 		}
+		loadOffsets = append(loadOffsets, offset)
+	}
 
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
-			// 构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
-			}
+	// 多个goroutine并发读取并解码数据文件，按文件id的顺序依次应用到索引中
+	scanner := db.scanDataFiles(loadFiles, loadOffsets)
+	defer scanner.stop()
 
-			// 解析key 拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
+	// 暂存所有事务数据
+	transactionRecords := make(map[uint64][]*scannedRecord)
+	// 每个事务第一条记录所在的文件id
+	transactionFirstFids := make(map[uint64]uint32)
+	var currentSeqNo = db.seqNo
+
+	for i, dataFile := range loadFiles {
+		result := scanner.result(i)
+		if result.err != nil {
+			return result.err
+		}
+
+		for _, scanned := range result.records {
+			if scanned.seqNo == nonTransactionSeqNo {
 				// 非事务操作 直接更新内存索引
-				db.index.Put(realKey, logRecordPos)
+				updateIndex(scanned)
 			} else {
 				// 事务完成，对应的seqNo的数据就可以更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				if scanned.record.Type == data.LogRecordTxnFinished {
+					for _, txnRecord := range transactionRecords[scanned.seqNo] {
+						updateIndex(txnRecord)
+					}
+					if firstFid, ok := transactionFirstFids[scanned.seqNo]; ok && firstFid != dataFile.FileId {
+						db.txnSpans[dataFile.FileId] = firstFid
 					}
-					delete(transactionRecords, seqNo)
+					delete(transactionRecords, scanned.seqNo)
+					delete(transactionFirstFids, scanned.seqNo)
 				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
+					if _, ok := transactionFirstFids[scanned.seqNo]; !ok {
+						transactionFirstFids[scanned.seqNo] = dataFile.FileId
+					}
+					transactionRecords[scanned.seqNo] = append(transactionRecords[scanned.seqNo], scanned)
 				}
 			}
 
 			// 更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
+			if scanned.seqNo > currentSeqNo {
+				currentSeqNo = scanned.seqNo
 			}
-
-			offset += size
 		}
 
 		// 如果当前是活跃文件，更新WriteOff
-		if fileId == db.activeFile.FileId {
-			db.activeFile.WriteOff = offset
+		if dataFile.FileId == db.activeFile.FileId {
+			db.activeFile.WriteOff = result.offset
 		}
 	}
 
+	batches.flush()
+
 	// 更新事务序列号
 	db.seqNo = currentSeqNo
 
 	return nil
 }
 
+// scannedRecord 从数据文件中解码出的一条记录，不保留value
+type scannedRecord struct {
+	record *data.LogRecord
+	nsId   uint32
+	seqNo  uint64
+	pos    *data.LogRecordPos
+}
+
+// scanResult 一个数据文件的读取结果，offset为读取结束的位置
+type scanResult struct {
+	records []*scannedRecord
+	offset  int64
+	err     error
+}
+
+// dataFileScanner 并发读取数据文件，按文件顺序依次返回读取结果
+// 同时在读取中或者读取完成但还未被消费的文件数量不超过LoadIndexWorkers，避免占用过多内存
+type dataFileScanner struct {
+	results []chan scanResult
+	tokens  chan struct{}
+	done    chan struct{}
+	wg      *sync.WaitGroup
+}
+
+func (db *DB) scanDataFiles(dataFiles []*data.DataFile, offsets []int64) *dataFileScanner {
+	workers := db.options.LoadIndexWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	scanner := &dataFileScanner{
+		results: make([]chan scanResult, len(dataFiles)),
+		tokens:  make(chan struct{}, workers),
+		done:    make(chan struct{}),
+		wg:      new(sync.WaitGroup),
+	}
+	for i := range scanner.results {
+		scanner.results[i] = make(chan scanResult, 1)
+	}
+
+	scanner.wg.Add(1)
+	go func() {
+		defer scanner.wg.Done()
+		// 按文件顺序分发，保证前面的文件总是先被读取
+		for i, dataFile := range dataFiles {
+			select {
+			case scanner.tokens <- struct{}{}:
+			case <-scanner.done:
+				return
+			}
+			scanner.wg.Add(1)
+			go func(i int, dataFile *data.DataFile) {
+				defer scanner.wg.Done()
+				records, offset, err := db.scanDataFile(dataFile, offsets[i])
+				scanner.results[i] <- scanResult{records: records, offset: offset, err: err}
+			}(i, dataFile)
+		}
+	}()
+	return scanner
+}
+
+// result 等待第i个文件读取完成，并释放一个名额
+func (s *dataFileScanner) result(i int) scanResult {
+	result := <-s.results[i]
+	<-s.tokens
+	return result
+}
+
+// stop 提前结束读取，并等待所有goroutine退出
+func (s *dataFileScanner) stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// scanDataFile 从offset开始读取数据文件中的所有记录，数据文件有对应的hint文件时直接读取hint文件
+func (db *DB) scanDataFile(dataFile *data.DataFile, offset int64) ([]*scannedRecord, int64, error) {
+	if offset == 0 {
+		if _, err := os.Stat(data.GetDataHintFileName(db.options.DirPath, dataFile.FileId)); err == nil {
+			return db.scanDataHintFile(dataFile)
+		}
+	}
+
+	// 顺序读取整个文件，读取完成之后不再占用页缓存
+	_ = dataFile.Advise(fio.AdviceSequential)
+	defer dataFile.Advise(fio.AdviceDontNeed)
+
+	var records []*scannedRecord
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if errors.Is(err, data.ErrInvalidCRC) {
+				db.recoveryCorruption(CorruptionInfo{FileId: dataFile.FileId, Offset: offset, Err: err})
+			}
+			return nil, 0, err
+		}
+
+		// 解析key 拿到命名空间以及事务序列号，key单独拷贝一份，不再持有value的内存
+		nsId := splitNamespace(logRecord)
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		logRecord.Key = append([]byte(nil), realKey...)
+		logRecord.Value = nil
+
+		records = append(records, &scannedRecord{
+			record: logRecord,
+			nsId:   nsId,
+			seqNo:  seqNo,
+			pos: &data.LogRecordPos{
+				Fid:    dataFile.FileId,
+				Offset: offset,
+				Size:   uint32(size),
+			},
+		})
+		offset += size
+	}
+	return records, offset, nil
+}
+
+// scanDataHintFile 读取数据文件对应的hint文件，hint文件中只包含有效数据以及删除标记的位置
+func (db *DB) scanDataHintFile(dataFile *data.DataFile) ([]*scannedRecord, int64, error) {
+	hintFile, err := data.OpenDataHintFile(db.options.DirPath, dataFile.FileId)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer hintFile.Close()
+
+	var records []*scannedRecord
+	var offset int64 = 0
+	for {
+		record, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if errors.Is(err, data.ErrInvalidCRC) {
+				db.recoveryCorruption(CorruptionInfo{FileId: dataFile.FileId, Offset: offset, Hint: true, Err: err})
+			}
+			return nil, 0, err
+		}
+		nsId := splitNamespace(record)
+		records = append(records, &scannedRecord{
+			record: &data.LogRecord{Key: record.Key, Type: record.Type},
+			nsId:   nsId,
+			seqNo:  nonTransactionSeqNo,
+			pos:    data.DecodeLogRecordPos(record.Value),
+		})
+		offset += size
+	}
+
+	fileSize, err := dataFile.IOManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, fileSize, nil
+}
+
 // loadDataFiles 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
 	// 遍历目录中所有文件，找到所有以.data结尾的数据文件
@@ -349,6 +711,16 @@ func (db *DB) loadDataFiles() error {
 	var fileIds []int
 
 	for _, file := range files {
+		// 文件压缩中途退出时留下的临时文件，只读时忽略
+		if filepath.Ext(file.Name()) == data.CompactFileSuffix {
+			if db.options.ReadOnly {
+				continue
+			}
+			if err := os.Remove(filepath.Join(db.options.DirPath, file.Name())); err != nil {
+				return err
+			}
+			continue
+		}
 		if filepath.Ext(file.Name()) != data.DataFileNameSuffix {
 			continue
 		}
@@ -366,11 +738,17 @@ func (db *DB) loadDataFiles() error {
 
 	// 遍历每个文件id，打开对应的数据文件
 	for i, fileId := range fileIds {
-		ioType := fio.StandardFIO
+		ioType := db.dataFileIOType()
 		if db.options.MMapAtStartup {
 			ioType = fio.MemoryMap
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fileId), ioType)
+		var dataFile *data.DataFile
+		// 只读时最后一个文件同样以只读方式打开，不需要写缓冲以及预分配
+		if i == len(fileIds)-1 && !db.options.MMapAtStartup && !db.options.ReadOnly {
+			dataFile, err = db.openActiveDataFile(uint32(fileId))
+		} else {
+			dataFile, err = data.OpenDataFile(db.options.DirPath, uint32(fileId), ioType)
+		}
 		if err != nil {
 			return err
 		}
@@ -384,6 +762,40 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
+// newIndexer 根据配置项创建索引，keyResolver用于哈希索引从数据文件中读取key
+func newIndexer(options Options, keyResolver index.KeyResolver) (index.Indexer, error) {
+	// B+树索引存储在磁盘上，不支持分片，可以开启布隆过滤器加速不存在key的查询
+	if options.IndexType == BPlusTree {
+		var bpt *index.BPlusTree
+		if options.ReadOnly {
+			var err error
+			if bpt, err = index.NewReadOnlyBPlusTree(options.DirPath); err != nil {
+				return nil, err
+			}
+		} else {
+			bpt = index.NewBPlusTree(options.DirPath, options.SyncWrites)
+		}
+		if options.BloomFilterFPRate > 0 {
+			if err := bpt.EnableBloomFilter(options.BloomFilterFPRate); err != nil {
+				_ = bpt.Close()
+				return nil, err
+			}
+		}
+		return bpt, nil
+	}
+
+	newIndex := func() index.Indexer {
+		if options.IndexType == KeyHash {
+			return index.NewKeyHashIndex(keyResolver)
+		}
+		return index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites)
+	}
+	if options.IndexShardNum > 1 {
+		return index.NewShardedIndex(options.IndexShardNum, newIndex), nil
+	}
+	return newIndex(), nil
+}
+
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
 		return ErrDirPathIsEmpty
@@ -391,54 +803,157 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return ErrDataFileSizeInvalid
 	}
+	// 哈希索引的位置信息中偏移量只有48位
+	if options.IndexType == KeyHash && options.DataFileSize > index.MaxKeyHashOffset {
+		return errors.New("data file size is too large for the key hash index")
+	}
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	if options.IndexShardNum < 0 {
+		return errors.New("invalid index shard num, must not be negative")
+	}
+	if options.BloomFilterFPRate < 0 || options.BloomFilterFPRate >= 1 {
+		return errors.New("invalid bloom filter false positive rate, must between 0 and 1")
+	}
+	if options.PreallocateSize < 0 {
+		return errors.New("invalid preallocate size, must not be negative")
+	}
+	if options.MaxDiskBytes < 0 {
+		return errors.New("invalid max disk bytes, must not be negative")
+	}
+	if options.DiskQuotaStallTimeout < 0 {
+		return errors.New("invalid disk quota stall timeout, must not be negative")
+	}
+	if options.SlowSyncThreshold < 0 {
+		return errors.New("invalid slow sync threshold, must not be negative")
+	}
+	if options.WriteBufferSize < 0 {
+		return errors.New("invalid write buffer size, must not be negative")
+	}
+	if options.ValueCacheSize < 0 {
+		return errors.New("invalid value cache size, must not be negative")
+	}
+	if options.LoadIndexWorkers < 0 {
+		return errors.New("invalid load index workers, must not be negative")
+	}
+	if options.MergeDir != "" && filepath.Clean(options.MergeDir) == filepath.Clean(options.DirPath) {
+		return errors.New("invalid merge dir, must not be the same as dir path")
+	}
 	return nil
 }
 
-func (db *DB) appendLogRecordWithLock(LogRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(LogRecord)
-}
-
 // Put 写入Key Value，Key不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.PutCtx(context.Background(), key, value)
+}
+
+// PutCtx 写入Key Value，ctx结束时不再写入，返回ContextError
+func (db *DB) PutCtx(ctx context.Context, key []byte, value []byte) error {
 	// 判断key是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := checkContext(ctx, "put"); err != nil {
+		return err
+	}
+
+	return db.retryOnDiskQuota(ctx, "put", func() error {
+		db.mu.Lock()
+		// 等待锁的期间ctx可能已经结束，写入之前再检查一次
+		if err := checkContext(ctx, "put"); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+
+		sharded, ok := db.index.(*index.ShardedIndex)
+		if !ok || db.options.IndexType == KeyHash || len(db.secondaryIndexes) > 0 {
+			defer db.mu.Unlock()
+			return db.putValue(key, value)
+		}
+		return db.putValueSharded(sharded, key, value)
+	})
+}
+
+// putValueSharded 持有db.mu追加写入数据，在db.mu之外更新分片索引，调用时需要持有db.mu，返回时已经释放
+// 哈希索引更新时需要读取数据文件，二级索引需要与主索引一起更新，这两种情况不使用
+func (db *DB) putValueSharded(sharded *index.ShardedIndex, key []byte, value []byte) error {
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:  data.LogRecordNormal,
+		Value: value,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+
+	// 在db.mu中锁住key所在分片的写入顺序，同一个分片上的更新按照数据文件中的位置顺序生效
+	apply := sharded.OrderedPut(key, pos)
+	db.mu.Unlock()
+	apply(db.addReclaimSize)
+	return nil
+}
+
+// putValue 在默认命名空间中写入数据并更新内存索引，需要持有db.mu
+func (db *DB) putValue(key []byte, value []byte) error {
+	return db.putValueIn(defaultNamespaceId, db.index, key, value)
+}
 
+// putValueIn 在nsId命名空间中写入数据并更新它的索引，需要持有db.mu
+func (db *DB) putValueIn(nsId uint32, idx index.Indexer, key []byte, value []byte) error {
 	// 构造LogRecord
 	logRecord := &data.LogRecord{
 		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type:  data.LogRecordNormal,
 		Value: value,
 	}
+	joinNamespace(logRecord, nsId)
 
 	// 追加写入到当前活跃数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
-	// 更新内存索引
-	if oldVal := db.index.Put(key, pos); oldVal != nil {
-		db.reclaimSize += int64(oldVal.Size)
+	// 更新内存索引，持有锁保证索引与数据文件的写入顺序一致
+	db.addReclaimSize(idx.Put(key, pos))
+	if nsId == defaultNamespaceId {
+		db.updateSecondaryIndexes(key, value)
 	}
 
 	return nil
 }
 
 func (db *DB) Delete(key []byte) error {
+	return db.DeleteCtx(context.Background(), key)
+}
+
+// DeleteCtx 删除key，ctx结束时不再写入，返回ContextError
+func (db *DB) DeleteCtx(ctx context.Context, key []byte) error {
 	// 判断key的有效性
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := checkContext(ctx, "delete"); err != nil {
+		return err
+	}
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := checkContext(ctx, "delete"); err != nil {
+		return err
+	}
+
+	return db.deleteValueIn(defaultNamespaceId, db.index, key)
+}
+
+// deleteValueIn 在nsId命名空间中删除key，需要持有db.mu
+func (db *DB) deleteValueIn(nsId uint32, idx index.Indexer, key []byte) error {
 	// 先检查key是否存在 不存在直接返回
-	if pos := db.index.Get(key); pos == nil {
+	if pos := idx.Get(key); pos == nil {
 		return nil
 	}
 
@@ -447,26 +962,47 @@ func (db *DB) Delete(key []byte) error {
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
+	joinNamespace(logRecord, nsId)
 	// 写入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
+	db.addReclaimSize(pos)
 	// 从内存索引中将key删除
-	oldPos, ok := db.index.Delete(key)
+	oldPos, ok := idx.Delete(key)
 	if !ok {
 		return ErrIndexUpdateFailed
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	db.addReclaimSize(oldPos)
+	if nsId == defaultNamespaceId {
+		db.removeFromSecondaryIndexes(key)
 	}
 
 	return nil
 }
 
+// addReclaimSize 记录pos位置的数据已经失效，同时更新对应数据文件的待回收字节数
+func (db *DB) addReclaimSize(pos *data.LogRecordPos) {
+	if pos == nil {
+		return
+	}
+	db.garbageMu.Lock()
+	defer db.garbageMu.Unlock()
+	db.reclaimSize += int64(pos.Size)
+	db.fileGarbage[pos.Fid] += int64(pos.Size)
+}
+
 // Put 追加写入到活跃数据文件中
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	return db.writeLogRecord(logRecord, true)
+}
+
+// writeLogRecord checkQuota为false时不检查磁盘限制，用于已经整体检查过的批量写入
+func (db *DB) writeLogRecord(logRecord *data.LogRecord, checkQuota bool) (*data.LogRecordPos, error) {
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
 	// 判断当前活跃数据文件是否存在（数据库在没有写入的情况下没有文件生成）
 	// 如果为空则初始化数据文件
 	if db.activeFile == nil {
@@ -478,22 +1014,29 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 
 	// 编码后写入
 	encRecord, size := data.EncodeLogRecord(logRecord)
+	if checkQuota && logRecordNeedsQuota(logRecord) {
+		if err := db.checkDiskQuota(size); err != nil {
+			return nil, err
+		}
+	}
 	// 如果写入的长度达到了活跃文件的阈值，关闭活跃文件，打开新的文件
 	if db.activeFile.WriteOff+int64(size) >= db.options.DataFileSize {
 		// 先持久化数据文件，保证已有的数据持久化到磁盘中
-		err := db.activeFile.Sync()
+		err := db.syncActiveFile()
 		if err != nil {
 			return nil, err
 		}
 
 		// 当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		oldFile := db.activeFile
+		db.olderFiles[oldFile.FileId] = oldFile
 
 		// 打开新的数据文件
 		err = db.setActiveDataFile()
 		if err != nil {
 			return nil, err
 		}
+		db.fileRotated(oldFile)
 	}
 	writeOff := db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
@@ -501,6 +1044,9 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	db.bytesWrite += uint(size)
+	if db.diskQuota != nil {
+		db.diskQuota.add(size)
+	}
 	// 根据用户配置决定是否需要持久化
 	var needSync = db.options.SyncWrites
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
@@ -508,7 +1054,7 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
 		// 清空累计值
@@ -535,7 +1081,7 @@ func (db *DB) setActiveDataFile() error {
 	}
 
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := db.openActiveDataFile(initialFileId)
 	if err != nil {
 		return err
 	}
@@ -543,35 +1089,126 @@ func (db *DB) setActiveDataFile() error {
 	return nil
 }
 
+// dataFileIOType 读取数据文件使用的IO类型，只读时不使用io_uring
+func (db *DB) dataFileIOType() fio.FileIOType {
+	if db.options.ReadOnly {
+		return fio.ReadOnlyFIO
+	}
+	if db.options.UseIOUring {
+		return fio.IOUring
+	}
+	return fio.StandardFIO
+}
+
+// openActiveDataFile 打开用于写入的活跃文件，开启写缓冲时使用带缓冲的IO
+func (db *DB) openActiveDataFile(fileId uint32) (*data.DataFile, error) {
+	var dataFile *data.DataFile
+	var err error
+	switch {
+	case db.directWrites:
+		dataFile, err = data.OpenDirectDataFile(db.options.DirPath, fileId, directWriteBufferSize)
+	case db.options.WriteBufferSize > 0:
+		dataFile, err = data.OpenBufferedDataFile(db.options.DirPath, fileId, db.options.WriteBufferSize)
+	default:
+		dataFile, err = data.OpenDataFile(db.options.DirPath, fileId, db.dataFileIOType())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if db.options.PreallocateSize > 0 {
+		if err := dataFile.SetPreallocate(db.options.PreallocateSize); err != nil {
+			_ = dataFile.Close()
+			return nil, err
+		}
+	}
+	return dataFile, nil
+}
+
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	return db.GetCtx(context.Background(), key)
+}
 
+// GetCtx 读取key对应的value，ctx结束时返回ContextError
+func (db *DB) GetCtx(ctx context.Context, key []byte) ([]byte, error) {
 	// 判断Key的合法性
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	if err := checkContext(ctx, "get"); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if err := checkContext(ctx, "get"); err != nil {
+		return nil, err
+	}
+
+	return db.getValue(key)
+}
+
+// indexGet 没有持有db.mu时查找索引，哈希索引可能需要从数据文件中读取key比较
+func (db *DB) indexGet(idx index.Indexer, key []byte) *data.LogRecordPos {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return idx.Get(key)
+}
+
+// getValue 读取默认命名空间中key对应的value，需要持有db.mu
+func (db *DB) getValue(key []byte) ([]byte, error) {
+	return db.getValueIn(db.index, key)
+}
 
+// getValueIn 从命名空间的索引idx中查找key并读取value，需要持有db.mu
+func (db *DB) getValueIn(idx index.Indexer, key []byte) ([]byte, error) {
 	// 从内存索引中获取Key对应的索引信息
-	logRecordPos := db.index.Get(key)
+	logRecordPos := idx.Get(key)
 	// 如果Key不在内存索引中 说明Key不存在
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
 	}
 
-	return db.getValueByPosition(logRecordPos)
+	return db.readValue(key, logRecordPos)
+}
+
+// readValue 读取索引中key对应位置的value，需要持有db.mu
+func (db *DB) readValue(key []byte, logRecordPos *data.LogRecordPos) ([]byte, error) {
+	logRecord, err := db.getLogRecordByPosition(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	return db.recordValue(key, logRecord)
+}
+
+// recordValue 校验读取到的记录确实属于key，返回key对应的value
+func (db *DB) recordValue(key []byte, logRecord *data.LogRecord) ([]byte, error) {
+	// 哈希索引只比较key的哈希值，需要校验记录中实际的key
+	if realKey, _ := parseLogRecordKey(logRecord.Key); !bytes.Equal(realKey, key) {
+		return nil, ErrKeyNotFound
+	}
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+
+	return db.resolveValue(key, logRecord)
 }
 
 // ListKeys 获取数据库中所有key
 func (db *DB) ListKeys() [][]byte {
-	iterator := db.index.Iterator(false)
-	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
+	return db.listKeys(db.index)
+}
 
-	var idx int
+func (db *DB) listKeys(indexer index.Indexer) [][]byte {
+	// 哈希索引遍历时需要从数据文件中读取key，持有读锁避免数据文件同时被切换或者删除
+	db.mu.RLock()
+	iterator := indexer.Iterator(false)
+	db.mu.RUnlock()
+	defer iterator.Close()
+	// 哈希索引跳过读取失败的key，并发写入时索引的大小也可能变化，不能按大小预先分配
+	keys := make([][]byte, 0, indexer.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		keys = append(keys, iterator.Key())
 	}
 
 	return keys
@@ -579,12 +1216,24 @@ func (db *DB) ListKeys() [][]byte {
 
 // Fold 获取所有数据，执行用户指定的fn，函数返回false时终止遍历
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.FoldCtx(context.Background(), fn)
+}
+
+// FoldCtx 获取所有数据，执行用户指定的fn，每处理一条数据之前检查ctx，ctx结束时返回ContextError
+func (db *DB) FoldCtx(ctx context.Context, fn func(key []byte, value []byte) bool) error {
+	if err := checkContext(ctx, "fold"); err != nil {
+		return err
+	}
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := checkContext(ctx, "fold"); err != nil {
+			return err
+		}
 		key := iterator.Key()
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
@@ -599,19 +1248,7 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 }
 
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
-	// 根据FileId找到对应的数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[logRecordPos.Fid]
-	}
-	// 数据文件为空
-	if dataFile == nil {
-		return nil, ErrDataFileNotFound
-	}
-	// 根据偏移读取对应的数据
-	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	logRecord, err := db.getLogRecordByPosition(logRecordPos)
 	if err != nil {
 		return nil, err
 	}
@@ -620,7 +1257,82 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 		return nil, ErrKeyNotFound
 	}
 
-	return logRecord.Value, nil
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	return db.resolveValue(realKey, logRecord)
+}
+
+// readKeyByPosition 读取位置对应记录的实际key，供哈希索引解决冲突使用
+func (db *DB) readKeyByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	var logRecord *data.LogRecord
+	var err error
+	// 开启缓存时优先从缓存中读取，否则只需要读取key，不需要为value分配内存
+	if db.valueCache != nil || logRecordPos.Size == 0 {
+		logRecord, err = db.getLogRecordByPosition(logRecordPos)
+	} else {
+		var dataFile *data.DataFile
+		if dataFile, err = db.getDataFile(logRecordPos.Fid); err == nil {
+			if logRecord, err = dataFile.ReadLogRecordKeyAt(logRecordPos.Offset, logRecordPos.Size); err == nil {
+				splitNamespace(logRecord)
+			}
+		}
+	}
+	if err != nil {
+		db.logger.Warn("read key from data file failed", "fid", logRecordPos.Fid, "offset", logRecordPos.Offset, "err", err)
+		return nil, err
+	}
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	return realKey, nil
+}
+
+// getLogRecordByPosition 根据位置信息读取数据文件中的完整记录，开启缓存时优先从缓存中读取
+func (db *DB) getLogRecordByPosition(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	if db.valueCache != nil {
+		if logRecord, ok := db.valueCache.get(logRecordPos); ok {
+			return logRecord, nil
+		}
+	}
+
+	return db.readLogRecord(logRecordPos)
+}
+
+// readLogRecord 从数据文件中读取记录，不查找缓存，读取之后放入缓存
+func (db *DB) readLogRecord(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	dataFile, err := db.getDataFile(logRecordPos.Fid)
+	if err != nil {
+		return nil, err
+	}
+	// 根据偏移读取对应的数据，索引中记录了数据的大小时只需要一次读取
+	var logRecord *data.LogRecord
+	if logRecordPos.Size > 0 {
+		logRecord, err = dataFile.ReadLogRecordAt(logRecordPos.Offset, logRecordPos.Size)
+	} else {
+		logRecord, _, err = dataFile.ReadLogRecord(logRecordPos.Offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 位置来自命名空间自己的索引，只需要去掉命名空间标记
+	splitNamespace(logRecord)
+	if db.valueCache != nil {
+		db.valueCache.put(logRecordPos, logRecord)
+	}
+
+	return logRecord, nil
+}
+
+// getDataFile 根据FileId找到对应的数据文件
+func (db *DB) getDataFile(fid uint32) (*data.DataFile, error) {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[fid]
+	}
+	// 数据文件为空
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	return dataFile, nil
 }
 
 func (db *DB) loadSeqNo() error {
@@ -629,16 +1341,36 @@ func (db *DB) loadSeqNo() error {
 		return nil
 	}
 
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenReadOnlyFile(fileName)
 	if err != nil {
 		return err
 	}
-	record, _, err := seqNoFile.ReadLogRecord(0)
+	defer seqNoFile.Close()
+
+	// 每次关闭时都会追加写入，最后一条记录才是最新的事务序列号
+	var record *data.LogRecord
+	var offset int64 = 0
+	for {
+		logRecord, size, err := seqNoFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		record = logRecord
+		offset += size
+	}
+	if record == nil {
+		return nil
+	}
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
 	}
-	db.seqNo = seqNo
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
 	db.seqNoFileExists = true
 
 	// 同样的 在windows下 os.remove会panic
@@ -651,12 +1383,16 @@ func (db *DB) resetIoType() error {
 		return nil
 	}
 
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	if db.options.WriteBufferSize > 0 && !db.options.ReadOnly {
+		if err := db.activeFile.SetBufferedIOManager(db.options.DirPath, db.options.WriteBufferSize); err != nil {
+			return err
+		}
+	} else if err := db.activeFile.SetIOManager(db.options.DirPath, db.dataFileIOType()); err != nil {
 		return err
 	}
 
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(db.options.DirPath, db.dataFileIOType()); err != nil {
 			return err
 		}
 	}
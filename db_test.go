@@ -0,0 +1,510 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_LoadIndexWorkers(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-load")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%500), utils.RandomValue(32)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// 事务中的数据跨越多个数据文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("batch-%d", i))))
+	}
+	assert.Nil(t, wb.Delete(utils.GetTestKey(999)))
+	assert.Nil(t, wb.Commit())
+	assert.Greater(t, len(db.olderFiles), 4)
+	assert.Greater(t, db.Stat().DiskSize, uint64(32*1024*4))
+	seqNo := db.seqNo
+	assert.Nil(t, db.Close())
+
+	// 不同的并发度加载的结果一致
+	var reclaimSize int64 = -1
+	for n, workers := range []int{0, 1, 3, 16} {
+		opts.LoadIndexWorkers = workers
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, seqNo, db.seqNo)
+		if reclaimSize == -1 {
+			reclaimSize = db.reclaimSize
+		}
+		assert.Equal(t, reclaimSize, db.reclaimSize)
+		assert.Equal(t, 999+n, len(db.ListKeys()))
+		for i := 0; i < 999; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("batch-%d", i)), val)
+		}
+
+		// 活跃文件的写入位置正确
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("after-reopen-%d", n)), []byte("v")))
+		assert.Nil(t, db.Close())
+	}
+}
+
+func TestDB_WriteBuffer(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-write-buffer")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.WriteBufferSize = 4 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	// 跨越多个数据文件，还在缓冲区中的数据同样可以读取
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i))))
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val)
+	}
+	assert.Greater(t, len(db.olderFiles), 1)
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	// 备份包括缓冲区中的数据
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-write-buffer-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.Backup(backupDir))
+	assert.Nil(t, db.Close())
+
+	for n, mmap := range []bool{true, false} {
+		opts.MMapAtStartup = mmap
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 999+n, len(db.ListKeys()))
+		// 重启之后活跃文件继续使用写缓冲
+		assert.Nil(t, db.Put([]byte("after-reopen"), []byte("v")))
+		val, err := db.Get([]byte("after-reopen"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), val)
+		assert.Nil(t, db.Close())
+	}
+
+	opts.DirPath = backupDir
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 999, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func BenchmarkOpen(b *testing.B) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bench-open")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024 * 1024
+
+	db, err := Open(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1000000; i++ {
+		if err := db.Put(utils.GetTestKey(i), utils.RandomValue(256)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			opts.LoadIndexWorkers = workers
+			for i := 0; i < b.N; i++ {
+				db, err := Open(opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = db.Close()
+			}
+		})
+	}
+}
+
+// 启动加载约10GB数据目录的耗时，数据目录由BITCASK_OPEN_BENCH_DIR指定，目录不存在时先写入1000万条记录，value 1KB
+// BITCASK_OPEN_BENCH_DIR=/tmp/bitcask-go-open-large go test -run xxx -bench BenchmarkOpenLarge -benchtime=1x -timeout 1h
+func BenchmarkOpenLarge(b *testing.B) {
+	dir := os.Getenv("BITCASK_OPEN_BENCH_DIR")
+	if dir == "" {
+		b.Skip("BITCASK_OPEN_BENCH_DIR is not set")
+	}
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 256 * 1024 * 1024
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		db, err := Open(opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		values := make([][]byte, 1024)
+		for i := range values {
+			values[i] = utils.RandomValue(1024)
+		}
+		for i := 0; i < 10000000; i++ {
+			if err := db.Put(utils.GetTestKey(i), values[i%len(values)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			opts.LoadIndexWorkers = workers
+			for i := 0; i < b.N; i++ {
+				db, err := Open(opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = db.Close()
+			}
+		})
+	}
+}
+
+func TestDB_ContextVariants(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ctx")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var ctxErr *ContextError
+	err = db.PutCtx(canceled, []byte("canceled"), []byte("v"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorAs(t, err, &ctxErr)
+	assert.Equal(t, "put", ctxErr.Op)
+	_, err = db.Get([]byte("canceled"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.GetCtx(canceled, utils.GetTestKey(0))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, db.DeleteCtx(canceled, utils.GetTestKey(0)), context.Canceled)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("v")))
+	assert.ErrorIs(t, wb.CommitCtx(canceled), context.Canceled)
+	_, err = db.Get([]byte("batch"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 遍历过程中取消
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int
+	err = db.FoldCtx(ctx, func(key []byte, value []byte) bool {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return true
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 10, count)
+
+	// 等待锁的期间超时，不会再写入数据
+	db.mu.Lock()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	errCh := make(chan error)
+	go func() {
+		errCh <- db.PutCtx(ctx, []byte("timeout"), []byte("v"))
+	}()
+	time.Sleep(time.Millisecond * 50)
+	db.mu.Unlock()
+	assert.ErrorIs(t, <-errCh, context.DeadlineExceeded)
+	_, err = db.Get([]byte("timeout"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexSnapshotInterval = time.Hour
+
+	// 只读时不创建数据目录
+	roOpts := opts
+	roOpts.ReadOnly = true
+	roOpts.DirPath = filepath.Join(dir, "missing")
+	_, err := Open(roOpts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(roOpts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	// 可写实例打开时只读实例无法打开
+	roOpts.DirPath = dir
+	_, err = Open(roOpts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	assert.Nil(t, db.Close())
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+
+	// 多个只读实例可以同时打开
+	db1, err := Open(roOpts)
+	assert.Nil(t, err)
+	db2, err := Open(roOpts)
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	val, err := db1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	assert.Equal(t, ErrReadOnly, db1.Put([]byte("k"), []byte("v")))
+	assert.Equal(t, ErrReadOnly, db1.Delete(utils.GetTestKey(1)))
+	wb := db1.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k"), []byte("v")))
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+	_, err = db1.Namespace("ns")
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, db1.Merge())
+	assert.Equal(t, ErrReadOnly, db1.CompactFiles(1))
+	assert.Nil(t, db1.Close())
+	assert.Nil(t, db2.Close())
+
+	// 关闭只读实例不修改数据目录
+	after, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(after))
+
+	// 文件锁不存在时不创建文件锁，数据文件以只读方式打开
+	assert.Nil(t, os.Remove(filepath.Join(dir, fileLockName)))
+	db1, err = Open(roOpts)
+	assert.Nil(t, err)
+	_, err = db1.activeFile.IOManager.Write([]byte("v"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 100, len(db1.ListKeys()))
+	assert.Nil(t, db1.Close())
+	after, err = os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries)-1, len(after))
+
+	// 没有B+树索引文件时无法以只读方式打开
+	_, err = Open(Options{DirPath: dir, DataFileSize: 1024, IndexType: BPlusTree, ReadOnly: true})
+	assert.NotNil(t, err)
+}
+
+func TestDB_ReadOnlyBPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only-bptree")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.BloomFilterFPRate = 0.01
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Close())
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	db1, err := Open(roOpts)
+	assert.Nil(t, err)
+	db2, err := Open(roOpts)
+	assert.Nil(t, err)
+	val, err := db1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	_, err = db1.Get([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrReadOnly, db1.Put([]byte("k"), []byte("v")))
+	assert.Nil(t, db1.Close())
+	assert.Nil(t, db2.Close())
+
+	after, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(after))
+}
+
+func TestDB_ShardedConcurrentPut(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexShardNum = 8
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 多个goroutine同时写入和删除相同的key，索引在db.mu之外更新
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := utils.GetTestKey(i % 50)
+				assert.Nil(t, db.Put(key, []byte(fmt.Sprintf("%d-%d", g, i))))
+				_, err := db.Get(key)
+				assert.True(t, err == nil || errors.Is(err, ErrKeyNotFound))
+				if i%7 == g {
+					assert.Nil(t, db.Delete(key))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 重启之后按数据文件中的顺序重建的索引与内存中的索引一致
+	values := make(map[string]string)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		values[string(key)] = string(value)
+		return true
+	}))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	reopened := make(map[string]string)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		reopened[string(key)] = string(value)
+		return true
+	}))
+	assert.Equal(t, values, reopened)
+	assert.Equal(t, len(values), int(db.Stat().KeyNum))
+}
+
+func TestDB_KeyHashConcurrentListKeys(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-keyhash")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 4096
+	opts.IndexType = KeyHash
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// 遍历时从数据文件中读取key，同时写入不断切换活跃文件
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for _, key := range db.ListKeys() {
+			assert.NotNil(t, key)
+		}
+		iterator := db.NewIterator(DefaultIteratorOptions)
+		iterator.Close()
+	}
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+func TestDB_StatDiskUsageError(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stat")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	var logs bytes.Buffer
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(16)))
+
+	// 数据目录被移走之后无法获取磁盘使用情况，Stat返回其他状态并记录日志
+	movedDir := dir + "-moved"
+	assert.Nil(t, os.Rename(dir, movedDir))
+	stat := db.Stat()
+	assert.Nil(t, os.Rename(movedDir, dir))
+	assert.Equal(t, uint(1), stat.KeyNum)
+	assert.Equal(t, uint64(0), stat.DiskSize)
+	assert.Contains(t, logs.String(), "get dir disk usage failed")
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_CloseTwice(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-close")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexSnapshotInterval = time.Hour
+	var closed []error
+	opts.EventListener.OnClose = func(err error) { closed = append(closed, err) }
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	value := utils.RandomValue(16)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), value))
+
+	// 生成快照失败时仍然关闭数据文件，返回快照的错误
+	tempDir := filepath.Join(dir, data.IndexSnapshotFileName+snapshotTempSuffix)
+	assert.Nil(t, os.MkdirAll(tempDir, os.ModePerm))
+	err = db.Close()
+	assert.NotNil(t, err)
+	assert.NotNil(t, db.activeFile.Sync())
+
+	// 重复关闭直接返回nil，不再通知
+	assert.Nil(t, db.Close())
+	assert.Equal(t, []error{err}, closed)
+
+	assert.Nil(t, os.Remove(tempDir))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, db.Close())
+}
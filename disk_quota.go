@@ -0,0 +1,200 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 每写入多少字节重新获取一次文件系统的剩余空间
+	diskQuotaRefreshBytes = 1024 * 1024
+	// 写入等待期间重新统计磁盘使用情况的间隔，也是超过限制之后写入时重新统计的最小间隔
+	diskQuotaPollInterval = 100 * time.Millisecond
+	// 估算批量写入的大小时每条记录除了key和value之外最多占用的字节数：header、事务序列号以及命名空间
+	logRecordOverhead = 32
+)
+
+// DiskQuotaEventType 磁盘限制事件类型
+type DiskQuotaEventType int8
+
+const (
+	// DiskQuotaExceeded 数据目录超过MaxDiskBytes或者剩余空间低于LowDiskWatermark，之后的写入被拒绝或者等待
+	DiskQuotaExceeded DiskQuotaEventType = iota + 1
+
+	// DiskQuotaRecovered 空间被释放，恢复写入
+	DiskQuotaRecovered
+
+	// DiskQuotaWriteStalled 一次写入开始等待空间释放
+	DiskQuotaWriteStalled
+)
+
+// DiskQuotaEvent 磁盘限制事件
+type DiskQuotaEvent struct {
+	Type          DiskQuotaEventType
+	DiskUsed      int64  // 数据目录当前占用的字节数
+	DiskAvailable uint64 // 数据目录所在文件系统剩余的字节数，没有设置LowDiskWatermark时为0
+}
+
+// diskQuota 数据目录的磁盘限制，写入时按写入的字节数累加使用量，不需要每次统计目录大小
+// 使用量是近似值，Open、文件压缩之后以及写入等待期间会重新统计
+type diskQuota struct {
+	dirPath      string
+	maxBytes     int64
+	lowWatermark uint64
+	stallTimeout time.Duration
+	callback     func(DiskQuotaEvent)
+
+	used         atomic.Int64
+	available    atomic.Uint64
+	sinceRefresh atomic.Int64
+	lastRefresh  atomic.Int64 // 上一次重新统计数据目录大小的时间，UnixNano
+	exceeded     atomic.Bool
+}
+
+func newDiskQuota(options Options) *diskQuota {
+	return &diskQuota{
+		dirPath:      options.DirPath,
+		maxBytes:     options.MaxDiskBytes,
+		lowWatermark: options.LowDiskWatermark,
+		stallTimeout: options.DiskQuotaStallTimeout,
+		callback:     options.DiskQuotaCallback,
+	}
+}
+
+// refresh 重新统计数据目录的大小以及文件系统的剩余空间，空间足够时解除限制
+func (q *diskQuota) refresh() error {
+	used, err := utils.DirSize(q.dirPath)
+	if err != nil {
+		return err
+	}
+	q.used.Store(used)
+	q.lastRefresh.Store(time.Now().UnixNano())
+	if err := q.refreshAvailable(); err != nil {
+		return err
+	}
+	if q.fits(0) && q.exceeded.CompareAndSwap(true, false) {
+		q.notify(DiskQuotaRecovered)
+	}
+	return nil
+}
+
+func (q *diskQuota) refreshAvailable() error {
+	q.sinceRefresh.Store(0)
+	if q.lowWatermark == 0 {
+		return nil
+	}
+	available, err := utils.AvailableDiskSize(q.dirPath)
+	if err != nil {
+		return err
+	}
+	q.available.Store(available)
+	return nil
+}
+
+// fits 再写入size字节之后是否仍然在限制之内
+func (q *diskQuota) fits(size int64) bool {
+	if q.maxBytes > 0 && q.used.Load()+size > q.maxBytes {
+		return false
+	}
+	if q.lowWatermark > 0 && q.available.Load() < q.lowWatermark+uint64(size) {
+		return false
+	}
+	return true
+}
+
+// allow 判断是否可以写入size字节，超过限制之后拒绝所有写入，直到重新统计时空间足够
+// 空间可能在merge、删除命名空间或者外部删除文件之后被释放，拒绝之前按diskQuotaPollInterval限制频率重新统计
+func (q *diskQuota) allow(size int64) bool {
+	if !q.exceeded.Load() && q.fits(size) {
+		return true
+	}
+	if time.Since(time.Unix(0, q.lastRefresh.Load())) >= diskQuotaPollInterval {
+		// 统计失败时无法确认空间是否已经释放，继续拒绝写入
+		if err := q.refresh(); err == nil && !q.exceeded.Load() && q.fits(size) {
+			return true
+		}
+	}
+	if q.exceeded.CompareAndSwap(false, true) {
+		q.notify(DiskQuotaExceeded)
+	}
+	return false
+}
+
+// add 记录写入了size字节
+func (q *diskQuota) add(size int64) {
+	q.used.Add(size)
+	if q.lowWatermark > 0 {
+		available := q.available.Load()
+		if uint64(size) > available {
+			available = uint64(size)
+		}
+		q.available.Store(available - uint64(size))
+		if q.sinceRefresh.Add(size) >= diskQuotaRefreshBytes {
+			_ = q.refreshAvailable()
+		}
+	}
+}
+
+func (q *diskQuota) notify(typ DiskQuotaEventType) {
+	if q.callback != nil {
+		q.callback(DiskQuotaEvent{Type: typ, DiskUsed: q.used.Load(), DiskAvailable: q.available.Load()})
+	}
+}
+
+// wait 等待空间被释放，直到deadline，期间定期重新统计磁盘使用情况
+func (q *diskQuota) wait(ctx context.Context, op string, deadline time.Time) error {
+	q.notify(DiskQuotaWriteStalled)
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	ticker := time.NewTicker(diskQuotaPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return &ContextError{Op: op, Err: ctx.Err()}
+		case <-timer.C:
+			return ErrDiskQuotaExceeded
+		case <-ticker.C:
+			if err := q.refresh(); err != nil {
+				return err
+			}
+			if !q.exceeded.Load() {
+				return nil
+			}
+		}
+	}
+}
+
+// checkDiskQuota 写入size字节之前检查磁盘限制，需要持有db.mu
+func (db *DB) checkDiskQuota(size int64) error {
+	if db.diskQuota != nil && !db.diskQuota.allow(size) {
+		return ErrDiskQuotaExceeded
+	}
+	return nil
+}
+
+// retryOnDiskQuota 执行写入，因为磁盘限制被拒绝并且设置了DiskQuotaStallTimeout时，等待空间释放之后重试
+// write中需要自己获取db.mu，等待期间不持有锁，merge以及文件压缩可以正常进行
+func (db *DB) retryOnDiskQuota(ctx context.Context, op string, write func() error) error {
+	var deadline time.Time
+	for {
+		err := write()
+		if err != ErrDiskQuotaExceeded || db.diskQuota.stallTimeout <= 0 {
+			return err
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(db.diskQuota.stallTimeout)
+		}
+		if err := db.diskQuota.wait(ctx, op, deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// logRecordNeedsQuota 删除记录不受磁盘限制，保证可以删除数据之后通过merge释放空间
+func logRecordNeedsQuota(logRecord *data.LogRecord) bool {
+	return logRecord.Type != data.LogRecordDeleted && logRecord.Type != data.LogRecordTxnFinished
+}
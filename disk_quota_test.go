@@ -0,0 +1,206 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fillDiskQuota 反复覆盖写入同一批key直到超过磁盘限制，旧的数据文件中产生大量待回收的数据
+func fillDiskQuota(t *testing.T, db *DB) {
+	for n := 0; ; n++ {
+		err := db.Put(utils.GetTestKey(n%100), utils.RandomValue(100))
+		if err == ErrDiskQuotaExceeded {
+			return
+		}
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_DiskQuota(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-quota")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.MaxDiskBytes = 96 * 1024
+	var mu sync.Mutex
+	var events []DiskQuotaEventType
+	opts.DiskQuotaCallback = func(event DiskQuotaEvent) {
+		mu.Lock()
+		events = append(events, event.Type)
+		mu.Unlock()
+	}
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	fillDiskQuota(t, db)
+	assert.Equal(t, []DiskQuotaEventType{DiskQuotaExceeded}, events)
+
+	// 批量写入整体被拒绝，删除不受限制
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("v")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrDiskQuotaExceeded, wb.Commit())
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	ns, err := db.Namespace("ns")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrDiskQuotaExceeded, ns.Put([]byte("k"), []byte("v")))
+
+	// 文件压缩释放空间之后恢复写入
+	assert.Nil(t, db.CompactFiles(10))
+	assert.Equal(t, []DiskQuotaEventType{DiskQuotaExceeded, DiskQuotaRecovered}, events)
+	assert.Nil(t, db.Put([]byte("after-compact"), []byte("v")))
+	assert.Nil(t, db.Close())
+
+	// 重启之后重新统计数据目录的大小
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	size, err := utils.DirSize(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, size, db.diskQuota.used.Load())
+	assert.Nil(t, db.Close())
+
+	// 文件系统的剩余空间低于水位线
+	opts.MaxDiskBytes = 0
+	opts.LowDiskWatermark = 1 << 62
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrDiskQuotaExceeded, db.Put([]byte("k"), []byte("v")))
+	assert.Nil(t, db.Delete([]byte("after-compact")))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_DiskQuotaStall(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-quota")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.MaxDiskBytes = 96 * 1024
+	var stalled sync.WaitGroup
+	stalled.Add(1)
+	var once sync.Once
+	opts.DiskQuotaCallback = func(event DiskQuotaEvent) {
+		if event.Type == DiskQuotaWriteStalled {
+			once.Do(stalled.Done)
+		}
+	}
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	// 不等待时直接返回错误
+	fillDiskQuota(t, db)
+
+	// 等待期间文件压缩释放空间，写入继续进行
+	db.diskQuota.stallTimeout = 10 * time.Second
+	done := make(chan error)
+	go func() {
+		done <- db.Put([]byte("stalled"), []byte("v"))
+	}()
+	stalled.Wait()
+	assert.Nil(t, db.CompactFiles(10))
+	assert.Nil(t, <-done)
+	val, err := db.Get([]byte("stalled"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+
+	// 超过等待时间之后返回错误
+	db.diskQuota.stallTimeout = 200 * time.Millisecond
+	start := time.Now()
+	fillDiskQuota(t, db)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestDB_DiskQuotaRecoverWithoutStall(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-quota")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.MaxDiskBytes = 96 * 1024
+	var mu sync.Mutex
+	var events []DiskQuotaEventType
+	opts.DiskQuotaCallback = func(event DiskQuotaEvent) {
+		mu.Lock()
+		events = append(events, event.Type)
+		mu.Unlock()
+	}
+
+	// 数据目录中其他程序写入的文件同样计入使用量
+	extraFile := filepath.Join(dir, "extra")
+	assert.Nil(t, os.WriteFile(extraFile, make([]byte, 48*1024), 0644))
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	fillDiskQuota(t, db)
+	assert.Equal(t, ErrDiskQuotaExceeded, db.Put([]byte("k"), []byte("v")))
+
+	// 不等待空间释放时，外部删除文件之后不需要重启也可以恢复写入
+	assert.Nil(t, os.Remove(extraFile))
+	time.Sleep(diskQuotaPollInterval)
+	assert.Nil(t, db.Put([]byte("k"), []byte("v")))
+	mu.Lock()
+	assert.Equal(t, []DiskQuotaEventType{DiskQuotaExceeded, DiskQuotaRecovered}, events)
+	mu.Unlock()
+}
+
+func TestDB_DiskQuotaStallAtomic(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-quota")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.MaxDiskBytes = 96 * 1024
+	stalled := make(chan struct{}, 1)
+	opts.DiskQuotaCallback = func(event DiskQuotaEvent) {
+		if event.Type == DiskQuotaWriteStalled {
+			select {
+			case stalled <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	fillDiskQuota(t, db)
+
+	// 原子操作与Put一样等待空间释放
+	db.diskQuota.stallTimeout = 10 * time.Second
+	done := make(chan error)
+	go func() {
+		_, err := db.Increment([]byte("counter"), 2)
+		done <- err
+	}()
+	select {
+	case <-stalled:
+	case err := <-done:
+		t.Fatalf("increment returned %v without waiting", err)
+	}
+	assert.Nil(t, db.CompactFiles(10))
+	assert.Nil(t, <-done)
+	counter, err := db.Increment([]byte("counter"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), counter)
+
+	// 超过等待时间之后返回错误
+	db.diskQuota.stallTimeout = 0
+	fillDiskQuota(t, db)
+	db.diskQuota.stallTimeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err = db.CompareAndSwap([]byte("cas"), nil, []byte("v"))
+	assert.Equal(t, ErrDiskQuotaExceeded, err)
+	_, err = db.PutIfAbsent([]byte("cas"), []byte("v"))
+	assert.Equal(t, ErrDiskQuotaExceeded, err)
+	assert.Equal(t, ErrDiskQuotaExceeded, db.Append([]byte("append"), []byte("v")))
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
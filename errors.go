@@ -1,6 +1,10 @@
 package bitcask_go
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrKeyIsEmpty             = errors.New("key is empty")
@@ -15,4 +19,38 @@ var (
 	ErrDatabaseIsUsing        = errors.New("database is using")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrCompactFileIdExhausted = errors.New("compaction output exceeds the reserved file ids")
+	ErrValueNotInteger        = errors.New("value is not an 8-byte integer")
+	ErrMergeOperatorMissing   = errors.New("merge operator is not configured")
+	ErrNamespaceNameIsEmpty   = errors.New("namespace name is empty")
+	ErrNamespaceNotFound      = errors.New("namespace not found")
+	ErrNamespaceDropped       = errors.New("namespace has been dropped")
+	ErrSecondaryIndexExists   = errors.New("secondary index already exists")
+	ErrSecondaryIndexNotFound = errors.New("secondary index not found")
+	ErrDiskQuotaExceeded      = errors.New("disk quota exceeded")
+	ErrReadOnly               = errors.New("database is opened in read-only mode")
+	// ErrNamespaceIndexUnsupported 命名空间的索引只能存放在内存中
+	ErrNamespaceIndexUnsupported = errors.New("namespaces do not support the B+ tree index")
 )
+
+// ContextError 操作因为ctx被取消或者超时而中止，Err为ctx.Err()
+type ContextError struct {
+	Op  string
+	Err error
+}
+
+func (e *ContextError) Error() string {
+	return fmt.Sprintf("%s aborted: %v", e.Op, e.Err)
+}
+
+func (e *ContextError) Unwrap() error {
+	return e.Err
+}
+
+// checkContext ctx已经结束时返回ContextError
+func checkContext(ctx context.Context, op string) error {
+	if err := ctx.Err(); err != nil {
+		return &ContextError{Op: op, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"time"
+)
+
+// Logger 日志接口，方法与*slog.Logger一致，可以直接使用slog.Default()，args为交替的key以及value
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// nopLogger 没有配置Logger时不输出日志
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// EventListener 数据库事件的回调，不需要的回调为nil即可
+// 回调可能在不同的goroutine中调用，部分回调调用时持有数据库锁，回调中不能读写数据库
+type EventListener struct {
+	// OnFileRotated 活跃文件切换为旧的数据文件，之后写入新的活跃文件，持有数据库锁
+	OnFileRotated func(info FileRotatedInfo)
+	// OnMergeBegin merge开始重写旧的数据文件
+	OnMergeBegin func(info MergeInfo)
+	// OnMergeEnd merge结束，包括失败以及取消
+	OnMergeEnd func(info MergeInfo)
+	// OnCompactionBegin 文件压缩开始重写挑选出的旧数据文件
+	OnCompactionBegin func(info CompactionInfo)
+	// OnCompactionEnd 文件压缩结束，包括失败
+	OnCompactionEnd func(info CompactionInfo)
+	// OnSnapshotFailed 后台定期生成索引快照失败，下一个周期会重新生成
+	OnSnapshotFailed func(err error)
+	// OnRecoveryCorruption 启动加载数据文件时发现损坏的记录，之后Open返回对应的错误
+	OnRecoveryCorruption func(info CorruptionInfo)
+	// OnSyncSlow 持久化活跃文件的耗时超过SlowSyncThreshold，持有数据库锁
+	OnSyncSlow func(info SyncSlowInfo)
+	// OnBackupDone 备份结束，包括失败
+	OnBackupDone func(info BackupInfo)
+	// OnClose 数据库关闭，err为Close的返回值
+	OnClose func(err error)
+}
+
+// FileRotatedInfo 活跃文件切换
+type FileRotatedInfo struct {
+	OldFileId uint32
+	NewFileId uint32
+	OldSize   int64 // 切换之前活跃文件写入的字节数
+}
+
+// MergeInfo merge的信息，Duration以及Err只在结束时有效
+type MergeInfo struct {
+	FileNum   int   // 参与merge的旧数据文件数量
+	TotalSize int64 // 参与merge的旧数据文件的总字节数
+	Duration  time.Duration
+	Err       error
+}
+
+// CompactionInfo 文件压缩的信息，Duration以及Err只在结束时有效
+type CompactionInfo struct {
+	FileIds   []uint32 // 被压缩的旧数据文件id
+	TotalSize int64    // 被压缩的旧数据文件的总字节数
+	Duration  time.Duration
+	Err       error
+}
+
+// CorruptionInfo 损坏的记录所在的位置
+type CorruptionInfo struct {
+	FileId uint32
+	Offset int64
+	Hint   bool // 是否是数据文件对应的hint文件
+	Err    error
+}
+
+// SyncSlowInfo 耗时过长的持久化
+type SyncSlowInfo struct {
+	FileId   uint32
+	Duration time.Duration
+}
+
+// BackupInfo 备份的信息
+type BackupInfo struct {
+	Dir      string
+	Duration time.Duration
+	Err      error
+}
+
+// syncActiveFile 持久化活跃文件，耗时超过SlowSyncThreshold时通知，需要持有db.mu
+func (db *DB) syncActiveFile() error {
+	start := time.Now()
+	if err := db.activeFile.Sync(); err != nil {
+		db.logger.Error("sync data file failed", "fileId", db.activeFile.FileId, "err", err)
+		return err
+	}
+	elapsed := time.Since(start)
+	if db.options.SlowSyncThreshold > 0 && elapsed >= db.options.SlowSyncThreshold {
+		db.logger.Warn("slow sync", "fileId", db.activeFile.FileId, "duration", elapsed)
+		if fn := db.options.EventListener.OnSyncSlow; fn != nil {
+			fn(SyncSlowInfo{FileId: db.activeFile.FileId, Duration: elapsed})
+		}
+	}
+	return nil
+}
+
+// fileRotated 活跃文件从oldFile切换到了新的文件，需要持有db.mu
+func (db *DB) fileRotated(oldFile *data.DataFile) {
+	info := FileRotatedInfo{OldFileId: oldFile.FileId, NewFileId: db.activeFile.FileId, OldSize: oldFile.WriteOff}
+	db.logger.Debug("data file rotated", "oldFileId", info.OldFileId, "newFileId", info.NewFileId, "oldSize", info.OldSize)
+	if fn := db.options.EventListener.OnFileRotated; fn != nil {
+		fn(info)
+	}
+}
+
+// recoveryCorruption 启动加载时发现损坏的记录
+func (db *DB) recoveryCorruption(info CorruptionInfo) {
+	db.logger.Error("corrupted log record found during recovery",
+		"fileId", info.FileId, "offset", info.Offset, "hint", info.Hint, "err", info.Err)
+	if fn := db.options.EventListener.OnRecoveryCorruption; fn != nil {
+		fn(info)
+	}
+}
@@ -0,0 +1,222 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_EventListener(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-events")
+	defer os.RemoveAll(dir)
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-events-backup")
+	defer os.RemoveAll(backupDir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.SlowSyncThreshold = 1
+
+	var rotated []FileRotatedInfo
+	var mergeBegin, mergeEnd []MergeInfo
+	var slowSyncs int
+	var backups []BackupInfo
+	var closed []error
+	opts.EventListener = EventListener{
+		OnFileRotated: func(info FileRotatedInfo) { rotated = append(rotated, info) },
+		OnMergeBegin:  func(info MergeInfo) { mergeBegin = append(mergeBegin, info) },
+		OnMergeEnd:    func(info MergeInfo) { mergeEnd = append(mergeEnd, info) },
+		OnSyncSlow:    func(info SyncSlowInfo) { slowSyncs++ },
+		OnBackupDone:  func(info BackupInfo) { backups = append(backups, info) },
+		OnClose:       func(err error) { closed = append(closed, err) },
+	}
+	var logs bytes.Buffer
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.True(t, len(rotated) > 0)
+	for i, info := range rotated {
+		assert.Equal(t, uint32(i), info.OldFileId)
+		assert.Equal(t, uint32(i+1), info.NewFileId)
+		assert.True(t, info.OldSize > 0)
+	}
+
+	assert.Nil(t, db.Sync())
+	assert.True(t, slowSyncs > 0)
+
+	// merge切换活跃文件之后开始重写旧的数据文件
+	rotatedBeforeMerge := len(rotated)
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, rotatedBeforeMerge+1, len(rotated))
+	assert.Equal(t, 1, len(mergeBegin))
+	assert.Equal(t, 1, len(mergeEnd))
+	assert.Equal(t, len(rotated), mergeBegin[0].FileNum)
+	assert.True(t, mergeBegin[0].TotalSize > 0)
+	assert.Equal(t, mergeBegin[0].TotalSize, mergeEnd[0].TotalSize)
+	assert.True(t, mergeEnd[0].Duration > 0)
+	assert.Nil(t, mergeEnd[0].Err)
+
+	assert.Nil(t, db.Backup(backupDir))
+	assert.Equal(t, 1, len(backups))
+	assert.Equal(t, backupDir, backups[0].Dir)
+	assert.Nil(t, backups[0].Err)
+
+	assert.Nil(t, db.Close())
+	assert.Equal(t, []error{nil}, closed)
+
+	output := logs.String()
+	for _, msg := range []string{"database opened", "data file rotated", "slow sync", "merge begin", "merge end", "backup done", "database closed"} {
+		assert.Contains(t, output, msg)
+	}
+	// merge使用的临时实例不输出日志
+	assert.Equal(t, 1, strings.Count(output, "database opened"))
+	assert.Equal(t, 1, strings.Count(output, "database closed"))
+}
+
+func TestDB_CompactionEvents(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-events")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+
+	var begin, end []CompactionInfo
+	opts.EventListener = EventListener{
+		OnCompactionBegin: func(info CompactionInfo) { begin = append(begin, info) },
+		OnCompactionEnd:   func(info CompactionInfo) { end = append(end, info) },
+	}
+	var logs bytes.Buffer
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	for n := 0; n < 3; n++ {
+		for i := 0; i < 300; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		}
+	}
+
+	assert.Nil(t, db.CompactFiles(2))
+	assert.Equal(t, 1, len(begin))
+	assert.Equal(t, 1, len(end))
+	assert.Equal(t, 2, len(begin[0].FileIds))
+	assert.True(t, begin[0].TotalSize > 0)
+	assert.Equal(t, begin[0].FileIds, end[0].FileIds)
+	assert.True(t, end[0].Duration > 0)
+	assert.Nil(t, end[0].Err)
+	assert.Contains(t, logs.String(), "compaction begin")
+	assert.Contains(t, logs.String(), "compaction end")
+}
+
+func TestDB_SnapshotFailed(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-events")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexSnapshotInterval = 10 * time.Millisecond
+
+	failed := make(chan error, 1)
+	opts.EventListener.OnSnapshotFailed = func(err error) {
+		select {
+		case failed <- err:
+		default:
+		}
+	}
+	var logs syncBuffer
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	// 快照的临时文件名被目录占用，生成快照失败
+	tempDir := filepath.Join(dir, data.IndexSnapshotFileName+snapshotTempSuffix)
+	assert.Nil(t, os.MkdirAll(tempDir, os.ModePerm))
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(16)))
+
+	select {
+	case err := <-failed:
+		assert.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("snapshot failure not reported")
+	}
+	assert.Contains(t, logs.String(), "index snapshot failed")
+	assert.Nil(t, os.Remove(tempDir))
+	assert.Nil(t, db.Close())
+}
+
+// syncBuffer 可以在多个goroutine中写入的日志输出
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDB_RecoveryCorruption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-corruption")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 修改第一条记录之后的一个字节
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[200] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	var corruptions []CorruptionInfo
+	opts.EventListener.OnRecoveryCorruption = func(info CorruptionInfo) {
+		corruptions = append(corruptions, info)
+	}
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Equal(t, 1, len(corruptions))
+	assert.Equal(t, uint32(0), corruptions[0].FileId)
+	assert.True(t, corruptions[0].Offset > 0)
+	assert.False(t, corruptions[0].Hint)
+}
+
+func TestDB_LoadMergeFilesError(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-load")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.MergeDir = filepath.Join(dir, "merge")
+
+	// 标识merge完成的文件内容损坏时不能忽略错误
+	assert.Nil(t, os.MkdirAll(opts.MergeDir, os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(opts.MergeDir, data.MergeFinishedFileName), []byte("broken"), 0644))
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,22 @@
+package fio
+
+// FileAdvice 文件的访问模式，用于提示操作系统的页缓存
+type FileAdvice = byte
+
+const (
+	// AdviceSequential 接下来会顺序读取整个文件，增大预读
+	AdviceSequential FileAdvice = iota
+	// AdviceDontNeed 文件的数据短时间内不会再被访问，从页缓存中清除
+	AdviceDontNeed
+)
+
+// Advisor 支持访问模式提示的IO，只在Linux上生效，其他平台忽略
+type Advisor interface {
+	Advise(advice FileAdvice) error
+}
+
+// Preallocator 支持预分配磁盘空间的IO，只在Linux上生效，其他平台忽略
+type Preallocator interface {
+	// SetPreallocate 写入时按chunkSize为单位预分配磁盘空间，不改变文件大小，关闭时释放没有使用的部分
+	SetPreallocate(chunkSize int64) error
+}
@@ -0,0 +1,118 @@
+package fio
+
+import "io"
+
+// BufferedFileIO 带写缓冲的标准文件IO，多次追加写入合并为一次系统调用
+// 缓冲区写满、Sync以及Close时写入文件，还没有写入文件的数据同样可以读取
+// 与FileIO一样，写入和读取不能并发进行，可以同时有多个读取
+type BufferedFileIO struct {
+	file       *FileIO
+	buf        []byte
+	bufferSize int
+	fileSize   int64 // 已经写入文件的数据大小
+}
+
+// NewBufferedIOManager 初始化 BufferedFileIO，bufferSize为缓冲区写满时的阈值
+func NewBufferedIOManager(fileName string, bufferSize int) (*BufferedFileIO, error) {
+	file, err := NewFileIOManager(fileName)
+	if err != nil {
+		return nil, err
+	}
+	fileSize, err := file.Size()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &BufferedFileIO{
+		file:       file,
+		buf:        make([]byte, 0, bufferSize),
+		bufferSize: bufferSize,
+		fileSize:   fileSize,
+	}, nil
+}
+
+func (b *BufferedFileIO) Read(p []byte, offset int64) (int, error) {
+	var n int
+	// 先从文件中读取已经写入的部分
+	if offset < b.fileSize {
+		end := int64(len(p))
+		if offset+end > b.fileSize {
+			end = b.fileSize - offset
+		}
+		m, err := b.file.Read(p[:end], offset)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if n == len(p) {
+			return n, nil
+		}
+	}
+
+	// 剩余的部分从缓冲区中读取
+	bufOffset := offset + int64(n) - b.fileSize
+	if bufOffset >= int64(len(b.buf)) {
+		return n, io.EOF
+	}
+	n += copy(p[n:], b.buf[bufOffset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *BufferedFileIO) Write(data []byte) (int, error) {
+	if len(b.buf)+len(data) > b.bufferSize {
+		if err := b.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	// 超过缓冲区大小的数据直接写入文件
+	if len(data) >= b.bufferSize {
+		n, err := b.file.Write(data)
+		b.fileSize += int64(n)
+		return n, err
+	}
+	b.buf = append(b.buf, data...)
+	return len(data), nil
+}
+
+// Flush 将缓冲区中的数据写入文件，不保证持久化
+func (b *BufferedFileIO) Flush() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	n, err := b.file.Write(b.buf)
+	b.fileSize += int64(n)
+	// 写入失败时保留没有写入的部分
+	b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+	return err
+}
+
+func (b *BufferedFileIO) Sync() error {
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	return b.file.Sync()
+}
+
+func (b *BufferedFileIO) Close() error {
+	if err := b.Flush(); err != nil {
+		_ = b.file.Close()
+		return err
+	}
+	return b.file.Close()
+}
+
+// Size 包括还在缓冲区中的数据，不需要获取文件信息
+func (b *BufferedFileIO) Size() (int64, error) {
+	return b.fileSize + int64(len(b.buf)), nil
+}
+
+func (b *BufferedFileIO) SetPreallocate(chunkSize int64) error {
+	return b.file.SetPreallocate(chunkSize)
+}
+
+func (b *BufferedFileIO) Advise(advice FileAdvice) error {
+	return b.file.Advise(advice)
+}
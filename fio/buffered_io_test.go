@@ -0,0 +1,74 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBufferedFileIO(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-fio")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.data")
+
+	bio, err := NewBufferedIOManager(path, 16)
+	assert.Nil(t, err)
+	n, err := bio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	n, err = bio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	// 缓冲区中的数据还没有写入文件，但是可以读取
+	stat, _ := os.Stat(path)
+	assert.Equal(t, int64(0), stat.Size())
+	size, _ := bio.Size()
+	assert.Equal(t, int64(10), size)
+	buf := make([]byte, 10)
+	n, err = bio.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-akey-b"), buf[:n])
+
+	// 缓冲区写满时写入文件
+	_, err = bio.Write([]byte("key-c-long"))
+	assert.Nil(t, err)
+	stat, _ = os.Stat(path)
+	assert.Equal(t, int64(10), stat.Size())
+
+	// 同时跨越文件和缓冲区读取
+	buf = make([]byte, 8)
+	n, err = bio.Read(buf, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ey-bkey-"), buf[:n])
+	// 超过数据末尾
+	n, err = bio.Read(buf, 16)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []byte("long"), buf[:n])
+
+	// 超过缓冲区大小的数据直接写入文件
+	_, err = bio.Write([]byte("a-very-long-value-d"))
+	assert.Nil(t, err)
+	stat, _ = os.Stat(path)
+	assert.Equal(t, int64(39), stat.Size())
+
+	_, err = bio.Write([]byte("e"))
+	assert.Nil(t, err)
+	assert.Nil(t, bio.Sync())
+	stat, _ = os.Stat(path)
+	assert.Equal(t, int64(40), stat.Size())
+	assert.Nil(t, bio.Close())
+
+	// 重新打开之后从文件末尾继续写入
+	bio, err = NewBufferedIOManager(path, 16)
+	assert.Nil(t, err)
+	_, err = bio.Write([]byte("f"))
+	assert.Nil(t, err)
+	size, _ = bio.Size()
+	assert.Equal(t, int64(41), size)
+	assert.Nil(t, bio.Close())
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "key-akey-bkey-c-longa-very-long-value-def", string(content))
+}
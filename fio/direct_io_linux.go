@@ -0,0 +1,165 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// directIOAlignment O_DIRECT要求内存地址、文件偏移以及长度都按块大小对齐
+const directIOAlignment = 4096
+
+// DirectFileIO 使用O_DIRECT绕过页缓存写入，用于merge等大量顺序写入的场景，避免挤占页缓存
+// 数据先写入对齐的缓冲区，写满之后整块写入，Sync时最后不完整的块补齐之后写入，再截断到实际大小
+// 读取使用另一个普通的文件描述符，与FileIO一样，写入和读取不能并发进行
+type DirectFileIO struct {
+	fd       *os.File // O_DIRECT写入
+	reader   *os.File
+	buf      []byte // 按块大小对齐的缓冲区
+	n        int    // 缓冲区中数据的长度
+	blockOff int64  // 缓冲区的数据在文件中的起始位置，按块大小对齐
+}
+
+// NewDirectIOManager 初始化 DirectFileIO，bufferSize会向上对齐到块大小
+// 文件系统不支持O_DIRECT时(例如tmpfs)使用 BufferedFileIO
+func NewDirectIOManager(fileName string, bufferSize int) (IOManager, error) {
+	reader, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(fileName, os.O_RDWR|unix.O_DIRECT, DataFilePerm)
+	if err != nil {
+		_ = reader.Close()
+		if errors.Is(err, unix.EINVAL) {
+			return NewBufferedIOManager(fileName, bufferSize)
+		}
+		return nil, err
+	}
+	stat, err := reader.Stat()
+	if err != nil {
+		_ = fd.Close()
+		_ = reader.Close()
+		return nil, err
+	}
+
+	bufferSize = (bufferSize + directIOAlignment - 1) &^ (directIOAlignment - 1)
+	if bufferSize == 0 {
+		bufferSize = directIOAlignment
+	}
+	dio := &DirectFileIO{
+		fd:       fd,
+		reader:   reader,
+		buf:      alignedBuffer(bufferSize),
+		blockOff: stat.Size() &^ (directIOAlignment - 1),
+	}
+	// 已有文件最后不完整的块读入缓冲区，之后和新写入的数据一起写入
+	dio.n, err = reader.ReadAt(dio.buf[:stat.Size()-dio.blockOff], dio.blockOff)
+	if err != nil && err != io.EOF {
+		_ = dio.Close()
+		return nil, err
+	}
+	return dio, nil
+}
+
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	shift := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1))
+	if shift != 0 {
+		shift = directIOAlignment - shift
+	}
+	return buf[shift : shift+size : shift+size]
+}
+
+func (d *DirectFileIO) Read(p []byte, offset int64) (int, error) {
+	var n int
+	if offset < d.blockOff {
+		end := int64(len(p))
+		if offset+end > d.blockOff {
+			end = d.blockOff - offset
+		}
+		m, err := d.reader.ReadAt(p[:end], offset)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if n == len(p) {
+			return n, nil
+		}
+	}
+
+	// 文件中最后的块可能还没有写入或者包含补齐的数据，从缓冲区中读取
+	bufOffset := offset + int64(n) - d.blockOff
+	if bufOffset >= int64(d.n) {
+		return n, io.EOF
+	}
+	n += copy(p[n:], d.buf[bufOffset:d.n])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *DirectFileIO) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		m := copy(d.buf[d.n:], data[written:])
+		d.n += m
+		written += m
+		if d.n == len(d.buf) {
+			if _, err := d.fd.WriteAt(d.buf, d.blockOff); err != nil {
+				d.n -= m
+				return written - m, err
+			}
+			d.blockOff += int64(d.n)
+			d.n = 0
+		}
+	}
+	return written, nil
+}
+
+// flush 缓冲区中的数据补齐到块大小写入文件，完整的块移出缓冲区，再将文件截断到实际的大小
+func (d *DirectFileIO) flush() error {
+	if d.n == 0 {
+		return nil
+	}
+	aligned := (d.n + directIOAlignment - 1) &^ (directIOAlignment - 1)
+	clear(d.buf[d.n:aligned])
+	if _, err := d.fd.WriteAt(d.buf[:aligned], d.blockOff); err != nil {
+		return err
+	}
+	size := d.blockOff + int64(d.n)
+	full := d.n &^ (directIOAlignment - 1)
+	d.n = copy(d.buf, d.buf[full:d.n])
+	d.blockOff += int64(full)
+	return d.fd.Truncate(size)
+}
+
+func (d *DirectFileIO) Sync() error {
+	if err := d.flush(); err != nil {
+		return err
+	}
+	return d.fd.Sync()
+}
+
+func (d *DirectFileIO) Close() error {
+	err := d.flush()
+	if closeErr := d.fd.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := d.reader.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (d *DirectFileIO) Size() (int64, error) {
+	return d.blockOff + int64(d.n), nil
+}
+
+func (d *DirectFileIO) Advise(advice FileAdvice) error {
+	return fadvise(d.reader, advice)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fio
+
+// NewDirectIOManager 只有Linux支持O_DIRECT，其他平台使用 BufferedFileIO
+func NewDirectIOManager(fileName string, bufferSize int) (IOManager, error) {
+	return NewBufferedIOManager(fileName, bufferSize)
+}
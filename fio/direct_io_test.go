@@ -0,0 +1,57 @@
+package fio
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectFileIO(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-fio")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.data")
+
+	dio, err := NewDirectIOManager(path, 8*1024)
+	assert.Nil(t, err)
+	var expected []byte
+	for i := 0; i < 500; i++ {
+		record := bytes.Repeat([]byte{byte(i)}, 37)
+		expected = append(expected, record...)
+		n, err := dio.Write(record)
+		assert.Nil(t, err)
+		assert.Equal(t, 37, n)
+		if i == 100 {
+			// Sync之后继续写入，最后不完整的块会被重新写入
+			assert.Nil(t, dio.Sync())
+			stat, _ := os.Stat(path)
+			assert.Equal(t, int64(len(expected)), stat.Size())
+		}
+	}
+	size, _ := dio.Size()
+	assert.Equal(t, int64(len(expected)), size)
+
+	// 跨越文件和缓冲区读取
+	buf := make([]byte, 1000)
+	n, err := dio.Read(buf, 8000)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[8000:9000], buf[:n])
+	n, err = dio.Read(buf, int64(len(expected))-10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, expected[len(expected)-10:], buf[:n])
+	assert.Nil(t, dio.Close())
+
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, expected, content)
+
+	// 重新打开之后从文件末尾继续写入
+	dio, err = NewDirectIOManager(path, 8*1024)
+	assert.Nil(t, err)
+	_, err = dio.Write([]byte("tail"))
+	assert.Nil(t, err)
+	assert.Nil(t, dio.Close())
+	content, _ = os.ReadFile(path)
+	assert.Equal(t, append(expected, "tail"...), content)
+}
@@ -7,7 +7,13 @@ import (
 
 // FileIO 标准系统文件IO
 type FileIO struct {
-	fd *os.File
+	fd       *os.File
+	readOnly bool // 文件是否是只读的，打开时检查一次
+
+	// 预分配磁盘空间，chunkSize为0时不预分配
+	chunkSize int64
+	allocEnd  int64 // 已经预分配到的位置
+	writeEnd  int64 // 文件实际的大小
 }
 
 func NewFileIOManager(fileName string) (*FileIO, error) {
@@ -15,7 +21,21 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &FileIO{fd: fd}, nil
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &FileIO{fd: fd, readOnly: stat.Mode()&0200 == 0}, nil
+}
+
+// NewReadOnlyFileIOManager 以只读方式打开已经存在的文件，写入返回错误
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd, readOnly: true}, nil
 }
 
 func (f *FileIO) Size() (int64, error) {
@@ -37,15 +57,54 @@ func (f *FileIO) Write(data []byte) (int, error) {
 	}
 
 	// 检查文件是否是以只读方式打开的
-	stat, err := f.fd.Stat()
+	if f.readOnly {
+		return 0, errors.New("file is read-only, write permission denied")
+	}
+
+	if f.chunkSize > 0 && f.writeEnd+int64(len(data)) > f.allocEnd {
+		if err := f.preallocate(int64(len(data))); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.fd.Write(data)
+	f.writeEnd += int64(n)
+	return n, err
+}
+
+// SetPreallocate 开启预分配，只用于写入的活跃文件
+func (f *FileIO) SetPreallocate(chunkSize int64) error {
+	size, err := f.Size()
 	if err != nil {
-		return 0, err
+		return err
 	}
-	if stat.Mode()&0200 == 0 {
-		return 0, errors.New("file is read-only, write permission denied")
+	f.chunkSize = chunkSize
+	f.writeEnd = size
+	f.allocEnd = size
+	return nil
+}
+
+// preallocate 从当前位置开始预分配至少能容纳n字节的空间
+func (f *FileIO) preallocate(n int64) error {
+	length := f.chunkSize
+	if n > length {
+		length = n
+	}
+	ok, err := fallocate(f.fd, f.writeEnd, length)
+	if err != nil {
+		return err
 	}
+	if !ok {
+		// 平台或者文件系统不支持
+		f.chunkSize = 0
+		return nil
+	}
+	f.allocEnd = f.writeEnd + length
+	return nil
+}
 
-	return f.fd.Write(data)
+// Advise 提示操作系统文件的访问模式
+func (f *FileIO) Advise(advice FileAdvice) error {
+	return fadvise(f.fd, advice)
 }
 
 func (f *FileIO) Sync() error {
@@ -53,5 +112,12 @@ func (f *FileIO) Sync() error {
 }
 
 func (f *FileIO) Close() error {
+	// 释放预分配但没有使用的磁盘空间
+	if f.allocEnd > f.writeEnd {
+		if err := f.fd.Truncate(f.writeEnd); err != nil {
+			_ = f.fd.Close()
+			return err
+		}
+	}
 	return f.fd.Close()
 }
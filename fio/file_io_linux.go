@@ -0,0 +1,28 @@
+//go:build linux
+
+package fio
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+func fadvise(fd *os.File, advice FileAdvice) error {
+	switch advice {
+	case AdviceSequential:
+		return unix.Fadvise(int(fd.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+	case AdviceDontNeed:
+		return unix.Fadvise(int(fd.Fd()), 0, 0, unix.FADV_DONTNEED)
+	}
+	return nil
+}
+
+// fallocate 预分配[offset, offset+length)的磁盘空间，文件大小保持不变
+// 文件系统不支持时返回false，之后不再尝试
+func fallocate(fd *os.File, offset, length int64) (bool, error) {
+	err := unix.Fallocate(int(fd.Fd()), unix.FALLOC_FL_KEEP_SIZE, offset, length)
+	if err == unix.EOPNOTSUPP || err == unix.ENOSYS {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileIO_Preallocate(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-fio")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.data")
+
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	assert.Nil(t, fio.SetPreallocate(1024*1024))
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	if fio.chunkSize == 0 {
+		t.Skip("fallocate is not supported")
+	}
+
+	// 预分配不改变文件大小
+	size, _ := fio.Size()
+	assert.Equal(t, int64(5), size)
+	var stat syscall.Stat_t
+	assert.Nil(t, syscall.Stat(path, &stat))
+	assert.GreaterOrEqual(t, stat.Blocks*512, int64(1024*1024))
+
+	// 关闭时释放没有使用的空间
+	assert.Nil(t, fio.Close())
+	assert.Nil(t, syscall.Stat(path, &stat))
+	assert.Less(t, stat.Blocks*512, int64(1024*1024))
+	assert.Equal(t, int64(5), stat.Size)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+func fadvise(fd *os.File, advice FileAdvice) error {
+	return nil
+}
+
+func fallocate(fd *os.File, offset, length int64) (bool, error) {
+	return false, nil
+}
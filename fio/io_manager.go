@@ -7,6 +7,10 @@ type FileIOType = byte
 const (
 	StandardFIO FileIOType = iota
 	MemoryMap
+	// IOUring 使用io_uring批量提交读取以及fsync并同步等待完成，实验性，只在Linux上生效，内核不支持时使用标准文件IO
+	IOUring
+	// ReadOnlyFIO 以只读方式打开已经存在的文件，不会创建文件，用于只读打开数据目录
+	ReadOnlyFIO
 )
 
 // IOManager 抽象IO管理接口
@@ -23,12 +27,29 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
+// ReadRequest 批量读取中的一次读取，读取完成之后N以及Err与IOManager.Read的返回值相同
+type ReadRequest struct {
+	Buf    []byte
+	Offset int64
+	N      int
+	Err    error
+}
+
+// BatchReader 支持一次提交多个读取的IO
+type BatchReader interface {
+	ReadBatch(reqs []ReadRequest)
+}
+
 func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 	switch ioType {
 	case StandardFIO:
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case IOUring:
+		return NewIOUringIOManager(fileName)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
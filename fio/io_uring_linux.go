@@ -0,0 +1,330 @@
+//go:build linux
+
+package fio
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	ioUringEntries = 128
+
+	ioUringOpReadv = 1
+	ioUringOpFsync = 3
+
+	ioUringEnterGetEvents = 1
+	ioUringFeatSingleMmap = 1
+
+	ioUringOffSQRing = 0
+	ioUringOffCQRing = 0x8000000
+	ioUringOffSQEs   = 0x10000000
+)
+
+// 以下结构与内核中io_uring的定义一致
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+type ioSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type ioCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ioUring 通过系统调用直接使用io_uring，所有文件共用一个实例，同一时间只有一个批次在提交
+// 提交是同步的：调用方在持有mu时提交并等待整个批次完成，并发的批次排队执行，收益只来自一次系统调用提交多个请求
+type ioUring struct {
+	mu      sync.Mutex
+	fd      int
+	entries uint32
+	broken  error // 提交出错之后请求的状态无法确定，不再使用
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	sqes    []ioUringSQE
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []ioUringCQE
+}
+
+var (
+	sharedIOUringOnce sync.Once
+	sharedIOUring     *ioUring
+	sharedIOUringErr  error
+)
+
+// getIOUring 第一次使用时创建共用的io_uring实例，进程退出之前不会释放
+func getIOUring() (*ioUring, error) {
+	sharedIOUringOnce.Do(func() {
+		sharedIOUring, sharedIOUringErr = newIOUring(ioUringEntries)
+	})
+	return sharedIOUring, sharedIOUringErr
+}
+
+// IOUringAvailable 当前内核是否可以使用io_uring
+func IOUringAvailable() bool {
+	_, err := getIOUring()
+	return err == nil
+}
+
+func newIOUring(entries uint32) (*ioUring, error) {
+	var params ioUringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	sqSize := int(params.sqOff.array + params.sqEntries*4)
+	cqSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(ioUringCQE{})))
+	singleMmap := params.features&ioUringFeatSingleMmap != 0
+	if singleMmap && cqSize > sqSize {
+		sqSize = cqSize
+	}
+
+	var mapped [][]byte
+	mmap := func(offset int64, size int) ([]byte, error) {
+		buf, err := unix.Mmap(int(fd), offset, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+		if err == nil {
+			mapped = append(mapped, buf)
+		}
+		return buf, err
+	}
+	cleanup := func(err error) (*ioUring, error) {
+		for _, buf := range mapped {
+			_ = unix.Munmap(buf)
+		}
+		_ = unix.Close(int(fd))
+		return nil, err
+	}
+
+	sqRing, err := mmap(ioUringOffSQRing, sqSize)
+	if err != nil {
+		return cleanup(err)
+	}
+	cqRing := sqRing
+	if !singleMmap {
+		if cqRing, err = mmap(ioUringOffCQRing, cqSize); err != nil {
+			return cleanup(err)
+		}
+	}
+	sqes, err := mmap(ioUringOffSQEs, int(params.sqEntries)*int(unsafe.Sizeof(ioUringSQE{})))
+	if err != nil {
+		return cleanup(err)
+	}
+
+	return &ioUring{
+		fd:      int(fd),
+		entries: params.sqEntries,
+		sqHead:  (*uint32)(unsafe.Pointer(&sqRing[params.sqOff.head])),
+		sqTail:  (*uint32)(unsafe.Pointer(&sqRing[params.sqOff.tail])),
+		sqMask:  *(*uint32)(unsafe.Pointer(&sqRing[params.sqOff.ringMask])),
+		sqArray: unsafe.Slice((*uint32)(unsafe.Pointer(&sqRing[params.sqOff.array])), params.sqEntries),
+		sqes:    unsafe.Slice((*ioUringSQE)(unsafe.Pointer(&sqes[0])), params.sqEntries),
+		cqHead:  (*uint32)(unsafe.Pointer(&cqRing[params.cqOff.head])),
+		cqTail:  (*uint32)(unsafe.Pointer(&cqRing[params.cqOff.tail])),
+		cqMask:  *(*uint32)(unsafe.Pointer(&cqRing[params.cqOff.ringMask])),
+		cqes:    unsafe.Slice((*ioUringCQE)(unsafe.Pointer(&cqRing[params.cqOff.cqes])), params.cqEntries),
+	}, nil
+}
+
+// submit 提交n个请求并等待全部完成，prep填充第i个请求，第i个请求的结果写入res[i]
+// 超过队列长度时分多次提交，每次提交都等待完成之后再提交下一批
+func (r *ioUring) submit(n int, prep func(i int, sqe *ioUringSQE), res []int32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.broken != nil {
+		return r.broken
+	}
+	for start := 0; start < n; start += int(r.entries) {
+		end := start + int(r.entries)
+		if end > n {
+			end = n
+		}
+		tail := atomic.LoadUint32(r.sqTail)
+		for i := start; i < end; i++ {
+			idx := tail & r.sqMask
+			sqe := &r.sqes[idx]
+			*sqe = ioUringSQE{}
+			prep(i, sqe)
+			sqe.userData = uint64(i)
+			r.sqArray[idx] = idx
+			tail++
+		}
+		atomic.StoreUint32(r.sqTail, tail)
+		if err := r.enter(uint32(end-start), res); err != nil {
+			r.broken = err
+			return err
+		}
+	}
+	return nil
+}
+
+// enter 提交队列中的n个请求并等待它们全部完成，结果写入res
+// 内核取走请求之后会移动sqHead，按队列中剩余的请求数计算还需要提交多少，不依赖系统调用的返回值，任何错误都不会重复或者遗漏提交
+// 出错之后不再提交剩余的请求，已经提交的请求仍然等待完成之后才返回，避免返回之后内核继续写入调用方的缓冲区
+func (r *ioUring) enter(n uint32, res []int32) error {
+	startHead := atomic.LoadUint32(r.sqTail) - n
+	var completed uint32
+	var err error
+	for {
+		sqHead := atomic.LoadUint32(r.sqHead)
+		if err != nil {
+			// 丢弃还没有提交的请求，持有mu时内核不会读取队列
+			atomic.StoreUint32(r.sqTail, sqHead)
+		}
+		toSubmit := atomic.LoadUint32(r.sqTail) - sqHead
+		inflight := sqHead - startHead - completed
+		if toSubmit == 0 && inflight == 0 {
+			return err
+		}
+
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd),
+			uintptr(toSubmit), uintptr(toSubmit+inflight), ioUringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != unix.EINTR && errno != unix.EAGAIN && errno != unix.EBUSY {
+			if err != nil {
+				// 等待已经提交的请求时再次出错，无法确定请求的状态，实例不再使用
+				return err
+			}
+			err = errno
+		}
+
+		head := atomic.LoadUint32(r.cqHead)
+		tail := atomic.LoadUint32(r.cqTail)
+		for ; head != tail; head++ {
+			cqe := &r.cqes[head&r.cqMask]
+			res[cqe.userData] = cqe.res
+			completed++
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+}
+
+// IOUringIO 使用io_uring的文件IO，批量读取以及fsync通过io_uring提交并同步等待完成，单次读取以及写入与 FileIO 相同
+type IOUringIO struct {
+	*FileIO
+	ring *ioUring
+	fd   int
+}
+
+// NewIOUringIOManager 初始化 IOUringIO，内核不支持io_uring时使用 FileIO
+func NewIOUringIOManager(fileName string) (IOManager, error) {
+	file, err := NewFileIOManager(fileName)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := getIOUring()
+	if err != nil {
+		return file, nil
+	}
+	return &IOUringIO{FileIO: file, ring: ring, fd: int(file.fd.Fd())}, nil
+}
+
+// ReadBatch 一次提交所有读取，读取的数据不足时剩余部分使用pread读取，保持与ReadAt相同的语义
+func (u *IOUringIO) ReadBatch(reqs []ReadRequest) {
+	iovecs := make([]unix.Iovec, len(reqs))
+	res := make([]int32, len(reqs))
+	err := u.ring.submit(len(reqs), func(i int, sqe *ioUringSQE) {
+		if len(reqs[i].Buf) > 0 {
+			iovecs[i].Base = &reqs[i].Buf[0]
+			iovecs[i].SetLen(len(reqs[i].Buf))
+		}
+		sqe.opcode = ioUringOpReadv
+		sqe.fd = int32(u.fd)
+		sqe.off = uint64(reqs[i].Offset)
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&iovecs[i])))
+		sqe.len = 1
+	}, res)
+	runtime.KeepAlive(iovecs)
+	runtime.KeepAlive(u.FileIO.fd)
+
+	for i := range reqs {
+		req := &reqs[i]
+		switch n := int(res[i]); {
+		case err != nil:
+			req.N, req.Err = u.Read(req.Buf, req.Offset)
+		case n < 0:
+			req.N, req.Err = 0, &os.PathError{Op: "read", Path: u.FileIO.fd.Name(), Err: unix.Errno(-n)}
+		case n < len(req.Buf):
+			m, err := u.Read(req.Buf[n:], req.Offset+int64(n))
+			req.N, req.Err = n+m, err
+		default:
+			req.N, req.Err = n, nil
+		}
+	}
+}
+
+func (u *IOUringIO) Sync() error {
+	res := make([]int32, 1)
+	err := u.ring.submit(1, func(i int, sqe *ioUringSQE) {
+		sqe.opcode = ioUringOpFsync
+		sqe.fd = int32(u.fd)
+	}, res)
+	runtime.KeepAlive(u.FileIO.fd)
+	if err != nil {
+		return u.FileIO.Sync()
+	}
+	if res[0] < 0 {
+		return &os.PathError{Op: "sync", Path: u.FileIO.fd.Name(), Err: unix.Errno(-res[0])}
+	}
+	return nil
+}
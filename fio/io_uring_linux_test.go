@@ -0,0 +1,186 @@
+//go:build linux
+
+package fio
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestIOUringIO(t *testing.T) {
+	if !IOUringAvailable() {
+		t.Skip("io_uring is not supported")
+	}
+	dir, _ := os.MkdirTemp("", "bitcask-go-fio")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.data")
+
+	ioManager, err := NewIOUringIOManager(path)
+	assert.Nil(t, err)
+	uio, ok := ioManager.(*IOUringIO)
+	assert.True(t, ok)
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(rand.Intn(256))
+	}
+	_, err = uio.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, uio.Sync())
+
+	// 超过队列长度的批次分多次提交
+	reqs := make([]ReadRequest, 300)
+	for i := range reqs {
+		reqs[i] = ReadRequest{Buf: make([]byte, 100), Offset: int64(i * 211)}
+	}
+	// 超过文件末尾的读取
+	reqs[299].Offset = int64(len(content) - 40)
+	uio.ReadBatch(reqs)
+	for i, req := range reqs[:299] {
+		assert.Nil(t, req.Err)
+		assert.Equal(t, 100, req.N)
+		assert.Equal(t, content[i*211:i*211+100], req.Buf)
+	}
+	assert.Equal(t, io.EOF, reqs[299].Err)
+	assert.Equal(t, 40, reqs[299].N)
+	assert.Equal(t, content[len(content)-40:], reqs[299].Buf[:40])
+	assert.Nil(t, uio.Close())
+}
+
+func TestIOUringIO_EnterError(t *testing.T) {
+	if !IOUringAvailable() {
+		t.Skip("io_uring is not supported")
+	}
+	dir, _ := os.MkdirTemp("", "bitcask-go-fio")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.data")
+
+	file, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("hello io_uring"))
+	assert.Nil(t, err)
+
+	// 关闭io_uring的fd之后提交失败，没有提交的请求被丢弃，读取退回到pread
+	ring, err := newIOUring(8)
+	assert.Nil(t, err)
+	assert.Nil(t, unix.Close(ring.fd))
+	uio := &IOUringIO{FileIO: file, ring: ring, fd: int(file.fd.Fd())}
+	reqs := []ReadRequest{{Buf: make([]byte, 5), Offset: 0}, {Buf: make([]byte, 8), Offset: 6}}
+	uio.ReadBatch(reqs)
+	assert.Nil(t, reqs[0].Err)
+	assert.Equal(t, []byte("hello"), reqs[0].Buf)
+	assert.Nil(t, reqs[1].Err)
+	assert.Equal(t, []byte("io_uring"), reqs[1].Buf)
+	assert.NotNil(t, ring.broken)
+	assert.Equal(t, *ring.sqHead, *ring.sqTail)
+	assert.Nil(t, uio.Sync())
+	assert.Nil(t, uio.Close())
+}
+
+func TestIOUringIO_Concurrent(t *testing.T) {
+	if !IOUringAvailable() {
+		t.Skip("io_uring is not supported")
+	}
+	dir, _ := os.MkdirTemp("", "bitcask-go-fio")
+	defer os.RemoveAll(dir)
+
+	// 多个文件并发提交批量读取，共用的实例依次处理每个批次
+	var wg sync.WaitGroup
+	for f := 0; f < 4; f++ {
+		ioManager, err := NewIOUringIOManager(filepath.Join(dir, fmt.Sprintf("%d.data", f)))
+		assert.Nil(t, err)
+		uio := ioManager.(*IOUringIO)
+		content := make([]byte, 16*1024)
+		for i := range content {
+			content[i] = byte(rand.Intn(256))
+		}
+		_, err = uio.Write(content)
+		assert.Nil(t, err)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer uio.Close()
+			for n := 0; n < 50; n++ {
+				reqs := make([]ReadRequest, 16)
+				for i := range reqs {
+					reqs[i] = ReadRequest{Buf: make([]byte, 64), Offset: rand.Int63n(int64(len(content) - 64))}
+				}
+				uio.ReadBatch(reqs)
+				for _, req := range reqs {
+					assert.Nil(t, req.Err)
+					assert.Equal(t, content[req.Offset:req.Offset+64], req.Buf)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func prepareReadBenchmark(b *testing.B) (string, []int64) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-fio-bench")
+	b.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "a.data")
+	fio, err := NewFileIOManager(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, 1024*1024)
+	for i := 0; i < 64; i++ {
+		if _, err := fio.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	_ = fio.Close()
+
+	offsets := make([]int64, 64)
+	for i := range offsets {
+		offsets[i] = rand.Int63n(64*1024*1024 - 4096)
+	}
+	return path, offsets
+}
+
+// BenchmarkRead 每次迭代随机读取64个4KB，对比逐个pread与io_uring批量提交
+func BenchmarkRead(b *testing.B) {
+	path, offsets := prepareReadBenchmark(b)
+	reqs := make([]ReadRequest, len(offsets))
+	for i := range reqs {
+		reqs[i] = ReadRequest{Buf: make([]byte, 4096), Offset: offsets[i]}
+	}
+
+	b.Run("FileIO", func(b *testing.B) {
+		fio, _ := NewFileIOManager(path)
+		defer fio.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := range reqs {
+				if _, err := fio.Read(reqs[j].Buf, reqs[j].Offset); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("IOUringIO", func(b *testing.B) {
+		if !IOUringAvailable() {
+			b.Skip("io_uring is not supported")
+		}
+		ioManager, _ := NewIOUringIOManager(path)
+		defer ioManager.Close()
+		uio := ioManager.(*IOUringIO)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			uio.ReadBatch(reqs)
+			for j := range reqs {
+				if reqs[j].Err != nil {
+					b.Fatal(reqs[j].Err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fio
+
+// NewIOUringIOManager 只有Linux支持io_uring，其他平台使用标准文件IO
+func NewIOUringIOManager(fileName string) (IOManager, error) {
+	return NewFileIOManager(fileName)
+}
+
+// IOUringAvailable 当前平台是否可以使用io_uring
+func IOUringAvailable() bool {
+	return false
+}
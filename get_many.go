@@ -0,0 +1,145 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"sort"
+	"sync"
+)
+
+// GetMany 批量读取多个key，返回的values以及errs与keys一一对应，key不存在时对应的err为ErrKeyNotFound
+func (db *DB) GetMany(keys [][]byte) ([][]byte, []error) {
+	return db.GetManyWithOptions(keys, DefaultGetManyOptions)
+}
+
+// GetManyWithOptions 批量读取多个key，先从索引中取出所有key的位置，按照文件id以及偏移排序后依次读取，
+// 同一个数据文件中的读取尽量是顺序的，可以配置同时读取多个数据文件
+func (db *DB) GetManyWithOptions(keys [][]byte, opts GetManyOptions) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	type lookup struct {
+		idx int
+		pos *data.LogRecordPos
+	}
+
+	// 整个批次只需要获取一次读锁
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	lookups := make([]lookup, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		pos := db.index.Get(key)
+		if pos == nil {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		lookups = append(lookups, lookup{idx: i, pos: pos})
+	}
+	sort.Slice(lookups, func(i, j int) bool {
+		if lookups[i].pos.Fid != lookups[j].pos.Fid {
+			return lookups[i].pos.Fid < lookups[j].pos.Fid
+		}
+		return lookups[i].pos.Offset < lookups[j].pos.Offset
+	})
+
+	// 每个goroutine负责读取一个数据文件中的数据，各自写入不同的下标，不需要加锁
+	readFile := func(lookups []lookup) {
+		positions := make([]*data.LogRecordPos, len(lookups))
+		for i, l := range lookups {
+			positions[i] = l.pos
+		}
+		logRecords, readErrs := db.getLogRecordsByPositions(positions)
+		for i, l := range lookups {
+			if readErrs[i] != nil {
+				errs[l.idx] = readErrs[i]
+				continue
+			}
+			values[l.idx], errs[l.idx] = db.recordValue(keys[l.idx], logRecords[i])
+		}
+	}
+	if opts.Concurrency <= 1 {
+		readFile(lookups)
+		return values, errs
+	}
+
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, opts.Concurrency)
+	for start := 0; start < len(lookups); {
+		end := start + 1
+		for end < len(lookups) && lookups[end].pos.Fid == lookups[start].pos.Fid {
+			end++
+		}
+		tokens <- struct{}{}
+		wg.Add(1)
+		go func(lookups []lookup) {
+			defer wg.Done()
+			readFile(lookups)
+			<-tokens
+		}(lookups[start:end])
+		start = end
+	}
+	wg.Wait()
+	return values, errs
+}
+
+// getLogRecordsByPositions 读取多个位置的记录，相邻的位置属于同一个数据文件时，没有命中缓存的读取一次提交
+func (db *DB) getLogRecordsByPositions(positions []*data.LogRecordPos) ([]*data.LogRecord, []error) {
+	logRecords := make([]*data.LogRecord, len(positions))
+	errs := make([]error, len(positions))
+
+	var misses []int
+	for i, pos := range positions {
+		if db.valueCache != nil {
+			if logRecord, ok := db.valueCache.get(pos); ok {
+				logRecords[i] = logRecord
+				continue
+			}
+		}
+		// 没有记录数据大小时只能先读取header
+		if pos.Size == 0 {
+			logRecords[i], errs[i] = db.readLogRecord(pos)
+			continue
+		}
+		misses = append(misses, i)
+	}
+
+	for start := 0; start < len(misses); {
+		fid := positions[misses[start]].Fid
+		end := start + 1
+		for end < len(misses) && positions[misses[end]].Fid == fid {
+			end++
+		}
+		group := misses[start:end]
+		start = end
+
+		dataFile, err := db.getDataFile(fid)
+		if err != nil {
+			for _, i := range group {
+				errs[i] = err
+			}
+			continue
+		}
+		offsets := make([]int64, len(group))
+		sizes := make([]uint32, len(group))
+		for j, i := range group {
+			offsets[j], sizes[j] = positions[i].Offset, positions[i].Size
+		}
+		records, readErrs := dataFile.ReadLogRecordsAt(offsets, sizes)
+		for j, i := range group {
+			if readErrs[j] != nil {
+				errs[i] = readErrs[j]
+				continue
+			}
+			splitNamespace(records[j])
+			if db.valueCache != nil {
+				db.valueCache.put(positions[i], records[j])
+			}
+			logRecords[i] = records[j]
+		}
+	}
+	return logRecords, errs
+}
@@ -0,0 +1,58 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_GetMany(t *testing.T) {
+	// 使用io_uring时同一个数据文件中的读取一次提交
+	for name, useIOUring := range map[string]bool{"standard": false, "io_uring": true} {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-get-many")
+			defer os.RemoveAll(dir)
+			opts.DirPath = dir
+			opts.DataFileSize = 32 * 1024
+			opts.UseIOUring = useIOUring
+
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			defer db.Close()
+
+			values := make(map[string][]byte)
+			for i := 0; i < 1000; i++ {
+				value := utils.RandomValue(64)
+				values[string(utils.GetTestKey(i))] = value
+				assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+			}
+			assert.Nil(t, db.Delete(utils.GetTestKey(10)))
+			assert.Greater(t, len(db.olderFiles), 1)
+
+			// 乱序的key，包括不存在、已删除以及重复的key
+			keys := [][]byte{utils.GetTestKey(999), utils.GetTestKey(10), nil, []byte("not-exist"), utils.GetTestKey(0)}
+			for i := 501; i > 0; i -= 7 {
+				keys = append(keys, utils.GetTestKey(i))
+			}
+			keys = append(keys, utils.GetTestKey(999))
+
+			for _, concurrency := range []int{1, 4} {
+				vals, errs := db.GetManyWithOptions(keys, GetManyOptions{Concurrency: concurrency})
+				assert.Equal(t, len(keys), len(vals))
+				assert.Equal(t, ErrKeyNotFound, errs[1])
+				assert.Equal(t, ErrKeyIsEmpty, errs[2])
+				assert.Equal(t, ErrKeyNotFound, errs[3])
+				for i, key := range keys {
+					if i >= 1 && i <= 3 {
+						assert.Nil(t, vals[i])
+						continue
+					}
+					assert.Nil(t, errs[i])
+					assert.Equal(t, values[string(key)], vals[i])
+				}
+			}
+		})
+	}
+}
@@ -69,6 +69,43 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func handleMultiGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	byteKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		byteKeys[i] = []byte(key)
+	}
+	values, errs := db.GetMany(byteKeys)
+
+	// 按请求中key的顺序返回，key不存在时found为false
+	res := make([]map[string]interface{}, len(keys))
+	for i, key := range keys {
+		if errs[i] != nil && !errors.Is(errs[i], bitcask.ErrKeyNotFound) && !errors.Is(errs[i], bitcask.ErrKeyIsEmpty) {
+			http.Error(w, errs[i].Error(), http.StatusInternalServerError)
+			log.Printf("fail to get key: %s, %v", key, errs[i])
+			return
+		}
+		res[i] = map[string]interface{}{
+			"key":   key,
+			"value": string(values[i]),
+			"found": errs[i] == nil,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
 func handleDelete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -102,6 +139,128 @@ func handleListKeys(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(res)
 }
 
+func handleCompareAndSwap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// old为空表示key不存在时才写入
+	var req struct {
+		Key string  `json:"key"`
+		Old *string `json:"old"`
+		New string  `json:"new"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var old []byte
+	if req.Old != nil {
+		old = []byte(*req.Old)
+	}
+	swapped, err := db.CompareAndSwap([]byte(req.Key), old, []byte(req.New))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("fail to compare and swap key: %s, %v", req.Key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":     req.Key,
+		"swapped": swapped,
+	})
+}
+
+func handlePutIfAbsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ok, err := db.PutIfAbsent([]byte(req.Key), []byte(req.Value))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("fail to put key if absent: %s, %v", req.Key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":     req.Key,
+		"written": ok,
+	})
+}
+
+func handleIncrement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Key   string `json:"key"`
+		Delta int64  `json:"delta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	value, err := db.Increment([]byte(req.Key), req.Delta)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, bitcask.ErrValueNotInteger) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		log.Printf("fail to increment key: %s, %v", req.Key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":   req.Key,
+		"value": value,
+	})
+}
+
+func handleAppend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.Append([]byte(req.Key), []byte(req.Value)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("fail to append key: %s, %v", req.Key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode("OK")
+}
+
 func handleStat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -116,9 +275,14 @@ func main() {
 	// 注册处理方法
 	http.HandleFunc("/bitcask/put", handlePut)
 	http.HandleFunc("/bitcask/get", handleGet)
+	http.HandleFunc("/bitcask/mget", handleMultiGet)
 	http.HandleFunc("/bitcask/delete", handleDelete)
 	http.HandleFunc("/bitcask/list-keys", handleListKeys)
 	http.HandleFunc("/bitcask/stat", handleStat)
+	http.HandleFunc("/bitcask/cas", handleCompareAndSwap)
+	http.HandleFunc("/bitcask/put-if-absent", handlePutIfAbsent)
+	http.HandleFunc("/bitcask/incr", handleIncrement)
+	http.HandleFunc("/bitcask/append", handleAppend)
 	// 启动http服务
 	_ = http.ListenAndServe("localhost:8080", nil)
 }
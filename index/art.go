@@ -68,6 +68,10 @@ func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
 
+func (art *AdaptiveRadixTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(art, ops)
+}
+
 // Art 索引迭代器
 type artIterator struct {
 	currentIndex int
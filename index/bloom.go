@@ -0,0 +1,136 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"sync/atomic"
+)
+
+// bloom filter 编码后头部的长度：fpRate + k + capacity + count
+const bloomHeaderSize = 8 + 4 + 8 + 8
+
+var ErrInvalidBloomFilter = errors.New("invalid bloom filter data")
+
+// BloomFilter 布隆过滤器，用于快速判断key一定不存在
+// 位数组使用原子操作读写，Add和MayContain可以并发调用
+type BloomFilter struct {
+	bits     []uint64
+	m        uint64  // 位数组的长度
+	k        uint32  // 哈希函数个数
+	capacity uint64  // 预期容纳的key数量，超过之后误判率会上升
+	count    uint64  // 已经添加的key数量
+	fpRate   float64 // 预期的误判率
+}
+
+// NewBloomFilter 根据预期的key数量以及误判率初始化布隆过滤器
+func NewBloomFilter(capacity uint64, fpRate float64) *BloomFilter {
+	if capacity == 0 {
+		capacity = 1
+	}
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	// 位数组长度按64对齐，解码时可以直接由数组长度得到
+	m = (m + 63) / 64 * 64
+	if m == 0 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return &BloomFilter{
+		bits:     make([]uint64, m/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+		fpRate:   fpRate,
+	}
+}
+
+// 计算key的两个哈希值，使用 h1 + i*h2 模拟k个哈希函数
+// 需要保证进程重启后结果一致，所以不能使用带随机种子的哈希
+func bloomHash(key []byte) (uint64, uint64) {
+	// FNV-1a
+	var h uint64 = 14695981039346656037
+	for _, b := range key {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	h2 := (h >> 33) ^ (h * 0x9e3779b97f4a7c15)
+	return h, h2 | 1
+}
+
+// Add 添加key
+func (bf *BloomFilter) Add(key []byte) {
+	h1, h2 := bloomHash(key)
+	for i := uint32(0); i < bf.k; i++ {
+		bit := (h1 + uint64(i)*h2) % bf.m
+		atomic.OrUint64(&bf.bits[bit/64], 1<<(bit%64))
+	}
+	atomic.AddUint64(&bf.count, 1)
+}
+
+// MayContain 判断key是否可能存在，返回false时key一定不存在
+func (bf *BloomFilter) MayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	for i := uint32(0); i < bf.k; i++ {
+		bit := (h1 + uint64(i)*h2) % bf.m
+		if atomic.LoadUint64(&bf.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Saturated 添加的key超过了预期容量，需要扩容重建
+func (bf *BloomFilter) Saturated() bool {
+	return atomic.LoadUint64(&bf.count) > bf.capacity
+}
+
+// Encode 编码布隆过滤器，用于持久化
+//
+//	+----------+-------+------------+---------+-------------+---------+
+//	|  fpRate  |   k   |  capacity  |  count  |    bits     |   crc   |
+//	+----------+-------+------------+---------+-------------+---------+
+//	   8字节     4字节     8字节        8字节      变长          4字节
+func (bf *BloomFilter) Encode() []byte {
+	buf := make([]byte, bloomHeaderSize+len(bf.bits)*8+crc32.Size)
+	binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(bf.fpRate))
+	binary.LittleEndian.PutUint32(buf[8:], bf.k)
+	binary.LittleEndian.PutUint64(buf[12:], bf.capacity)
+	binary.LittleEndian.PutUint64(buf[20:], atomic.LoadUint64(&bf.count))
+	index := bloomHeaderSize
+	for i := range bf.bits {
+		binary.LittleEndian.PutUint64(buf[index:], atomic.LoadUint64(&bf.bits[i]))
+		index += 8
+	}
+	binary.LittleEndian.PutUint32(buf[index:], crc32.ChecksumIEEE(buf[:index]))
+	return buf
+}
+
+// DecodeBloomFilter 解码布隆过滤器
+func DecodeBloomFilter(buf []byte) (*BloomFilter, error) {
+	if len(buf) < bloomHeaderSize+crc32.Size || (len(buf)-bloomHeaderSize-crc32.Size)%8 != 0 {
+		return nil, ErrInvalidBloomFilter
+	}
+	crcIndex := len(buf) - crc32.Size
+	if crc32.ChecksumIEEE(buf[:crcIndex]) != binary.LittleEndian.Uint32(buf[crcIndex:]) {
+		return nil, ErrInvalidBloomFilter
+	}
+	bf := &BloomFilter{
+		fpRate:   math.Float64frombits(binary.LittleEndian.Uint64(buf[0:])),
+		k:        binary.LittleEndian.Uint32(buf[8:]),
+		capacity: binary.LittleEndian.Uint64(buf[12:]),
+		count:    binary.LittleEndian.Uint64(buf[20:]),
+		bits:     make([]uint64, (crcIndex-bloomHeaderSize)/8),
+	}
+	bf.m = uint64(len(bf.bits)) * 64
+	for i := range bf.bits {
+		bf.bits[i] = binary.LittleEndian.Uint64(buf[bloomHeaderSize+i*8:])
+	}
+	if bf.k == 0 || bf.m == 0 {
+		return nil, ErrInvalidBloomFilter
+	}
+	return bf, nil
+}
@@ -0,0 +1,81 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	bf := NewBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		bf.Add(testKey(i))
+	}
+	for i := 0; i < 10000; i++ {
+		assert.True(t, bf.MayContain(testKey(i)))
+	}
+	assert.False(t, bf.Saturated())
+
+	// 误判率接近配置值
+	var falsePositive int
+	for i := 10000; i < 20000; i++ {
+		if bf.MayContain(testKey(i)) {
+			falsePositive++
+		}
+	}
+	assert.Less(t, falsePositive, 300)
+
+	// 编码后解码结果一致
+	bf2, err := DecodeBloomFilter(bf.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, bf.m, bf2.m)
+	assert.Equal(t, bf.k, bf2.k)
+	for i := 0; i < 20000; i++ {
+		assert.Equal(t, bf.MayContain(testKey(i)), bf2.MayContain(testKey(i)))
+	}
+
+	buf := bf.Encode()
+	buf[bloomHeaderSize] ^= 0xff
+	_, err = DecodeBloomFilter(buf)
+	assert.Equal(t, ErrInvalidBloomFilter, err)
+}
+
+func TestBPlusTree_BloomFilter(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bptree-bloom")
+	defer os.RemoveAll(dir)
+
+	bpt := NewBPlusTree(dir, false)
+	assert.Nil(t, bpt.EnableBloomFilter(0.01))
+	for i := 0; i < 100; i++ {
+		bpt.Put(testKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, bpt.Get(testKey(1000)))
+	_, ok := bpt.Delete(testKey(1000))
+	assert.False(t, ok)
+	assert.Equal(t, int64(10), bpt.Get(testKey(10)).Offset)
+	assert.Nil(t, bpt.Close())
+
+	// 重新打开时加载持久化的过滤器
+	_, err := os.Stat(filepath.Join(dir, BloomFilterFileName))
+	assert.Nil(t, err)
+	bpt = NewBPlusTree(dir, false)
+	assert.Nil(t, bpt.EnableBloomFilter(0.01))
+	assert.Equal(t, uint64(100), bpt.filter.Load().count)
+	for i := 0; i < 100; i++ {
+		assert.NotNil(t, bpt.Get(testKey(i)))
+	}
+
+	// 不开启过滤器写入数据之后，持久化的过滤器过期，需要重新构建
+	bpt.filter.Store(nil)
+	assert.Nil(t, bpt.Close())
+	bpt = NewBPlusTree(dir, false)
+	bpt.Put(testKey(200), &data.LogRecordPos{Fid: 1, Offset: 200})
+	assert.Nil(t, bpt.Close())
+	bpt = NewBPlusTree(dir, false)
+	assert.Nil(t, bpt.EnableBloomFilter(0.01))
+	assert.NotNil(t, bpt.Get(testKey(200)))
+	assert.Equal(t, 101, bpt.Size())
+	assert.Nil(t, bpt.Close())
+}
@@ -2,17 +2,33 @@ package index
 
 import (
 	"bitcask-go/data"
+	"encoding/binary"
+	"fmt"
 	"go.etcd.io/bbolt"
+	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 )
 
-const bptreeIndexFileName = "bptree-index"
+const (
+	bptreeIndexFileName = "bptree-index"
+	// BloomFilterFileName 持久化布隆过滤器的文件
+	BloomFilterFileName = "bptree-bloom"
+	// 布隆过滤器的最小容量
+	minBloomCapacity = 1 << 16
+)
 
 var indexBucketName = []byte("bitcask-index")
 
 // BPlusTree B+树索引
 type BPlusTree struct {
-	tree *bbolt.DB
+	tree       *bbolt.DB
+	dirPath    string
+	filter     atomic.Pointer[BloomFilter] // 布隆过滤器，为空表示没有开启
+	filterLock *sync.Mutex                 // 保证重建过滤器期间不会遗漏新写入的key
+	fpRate     float64
+	readOnly   bool // 只读打开时关闭时不保存布隆过滤器
 }
 
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
@@ -23,27 +39,152 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 		panic("failed to open bptree")
 	}
 
-	// 创建对应的bucket
-	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(indexBucketName))
+	// 创建对应的bucket，bucket已存在时不开启写事务，避免修改事务id
+	var bucketExists bool
+	_ = bptree.View(func(tx *bbolt.Tx) error {
+		bucketExists = tx.Bucket(indexBucketName) != nil
+		return nil
+	})
+	if !bucketExists {
+		if err := bptree.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(indexBucketName))
+			return err
+		}); err != nil {
+			panic("failed to create bucket")
+		}
+	}
+
+	return &BPlusTree{
+		tree:       bptree,
+		dirPath:    dirPath,
+		filterLock: new(sync.Mutex),
+	}
+}
+
+// NewReadOnlyBPlusTree 以只读方式打开已经存在的B+树索引，不会创建索引文件，只能读取
+func NewReadOnlyBPlusTree(dirPath string) (*BPlusTree, error) {
+	opts := *bbolt.DefaultOptions
+	opts.ReadOnly = true
+	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, &opts)
+	if err != nil {
+		return nil, err
+	}
+	var bucketExists bool
+	_ = bptree.View(func(tx *bbolt.Tx) error {
+		bucketExists = tx.Bucket(indexBucketName) != nil
+		return nil
+	})
+	if !bucketExists {
+		_ = bptree.Close()
+		return nil, fmt.Errorf("bucket %s not found in %s", indexBucketName, bptreeIndexFileName)
+	}
+
+	return &BPlusTree{
+		tree:       bptree,
+		dirPath:    dirPath,
+		filterLock: new(sync.Mutex),
+		readOnly:   true,
+	}, nil
+}
+
+// EnableBloomFilter 开启布隆过滤器，不存在的key不需要开启bbolt事务即可返回
+// 优先加载持久化的过滤器，文件不存在或者已经过期时遍历索引重新构建
+func (bpt *BPlusTree) EnableBloomFilter(fpRate float64) error {
+	bpt.fpRate = fpRate
+	buf, err := os.ReadFile(filepath.Join(bpt.dirPath, BloomFilterFileName))
+	if err != nil && !os.IsNotExist(err) {
 		return err
-	}); err != nil {
-		panic("failed to create bucket")
 	}
+	// 文件中记录了保存时bbolt的事务id，事务id不一致说明之后索引有过修改，过滤器已经过期
+	if len(buf) > 8 && binary.LittleEndian.Uint64(buf[:8]) == bpt.txId() {
+		bf, err := DecodeBloomFilter(buf[8:])
+		if err == nil && bf.fpRate == fpRate && !bf.Saturated() {
+			bpt.filter.Store(bf)
+			return nil
+		}
+	}
+	return bpt.RebuildBloomFilter()
+}
+
+// RebuildBloomFilter 遍历所有key重新构建布隆过滤器，用于清除已删除key的影响以及扩容
+func (bpt *BPlusTree) RebuildBloomFilter() error {
+	if bpt.fpRate <= 0 {
+		return nil
+	}
+	bpt.filterLock.Lock()
+	defer bpt.filterLock.Unlock()
+
+	return bpt.tree.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		capacity := uint64(bucket.Stats().KeyN) * 2
+		if capacity < minBloomCapacity {
+			capacity = minBloomCapacity
+		}
+		bf := NewBloomFilter(capacity, bpt.fpRate)
+		if err := bucket.ForEach(func(k, _ []byte) error {
+			bf.Add(k)
+			return nil
+		}); err != nil {
+			return err
+		}
+		bpt.filter.Store(bf)
+		return nil
+	})
+}
+
+// 持久化布隆过滤器，先写临时文件再重命名，保证文件完整
+func (bpt *BPlusTree) saveBloomFilter() error {
+	bf := bpt.filter.Load()
+	if bf == nil {
+		return nil
+	}
+	enc := bf.Encode()
+	buf := make([]byte, 8+len(enc))
+	binary.LittleEndian.PutUint64(buf[:8], bpt.txId())
+	copy(buf[8:], enc)
+
+	fileName := filepath.Join(bpt.dirPath, BloomFilterFileName)
+	if err := os.WriteFile(fileName+".tmp", buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
 
-	return &BPlusTree{tree: bptree}
+// 获取bbolt当前的事务id，每次写事务提交后都会递增
+func (bpt *BPlusTree) txId() uint64 {
+	var id int
+	_ = bpt.tree.View(func(tx *bbolt.Tx) error {
+		id = tx.ID()
+		return nil
+	})
+	return uint64(id)
 }
 
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	var oldValue []byte
 
+	bpt.filterLock.Lock()
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte(indexBucketName))
 		oldValue = bucket.Get(key)
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	}); err != nil {
+		bpt.filterLock.Unlock()
 		panic("failed to put key into bptree")
 	}
+	var saturated bool
+	if bf := bpt.filter.Load(); bf != nil {
+		bf.Add(key)
+		saturated = bf.Saturated()
+	}
+	bpt.filterLock.Unlock()
+
+	// 超过过滤器的容量之后扩容重建
+	if saturated {
+		if err := bpt.RebuildBloomFilter(); err != nil {
+			panic(fmt.Sprintf("failed to rebuild bloom filter: %v", err))
+		}
+	}
 
 	if len(oldValue) == 0 {
 		return nil
@@ -52,7 +193,68 @@ func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 	return data.DecodeLogRecordPos(oldValue)
 }
 
+// ApplyBatch 在一个bbolt事务中完成所有操作，避免每个key单独提交事务
+func (bpt *BPlusTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	if len(ops) == 0 {
+		return nil
+	}
+	oldValues := make([][]byte, len(ops))
+
+	bpt.filterLock.Lock()
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for i, op := range ops {
+			// bbolt返回的数据只在事务内有效，需要拷贝
+			if oldValue := bucket.Get(op.Key); len(oldValue) != 0 {
+				oldValues[i] = append([]byte(nil), oldValue...)
+			}
+			var err error
+			if op.Type == data.LogRecordDeleted {
+				err = bucket.Delete(op.Key)
+			} else {
+				err = bucket.Put(op.Key, data.EncodeLogRecordPos(op.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		bpt.filterLock.Unlock()
+		panic("failed to apply batch to bptree")
+	}
+	var saturated bool
+	if bf := bpt.filter.Load(); bf != nil {
+		for _, op := range ops {
+			if op.Type != data.LogRecordDeleted {
+				bf.Add(op.Key)
+			}
+		}
+		saturated = bf.Saturated()
+	}
+	bpt.filterLock.Unlock()
+
+	if saturated {
+		if err := bpt.RebuildBloomFilter(); err != nil {
+			panic(fmt.Sprintf("failed to rebuild bloom filter: %v", err))
+		}
+	}
+
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	for i, oldValue := range oldValues {
+		if oldValue != nil {
+			oldPositions[i] = data.DecodeLogRecordPos(oldValue)
+		}
+	}
+	return oldPositions
+}
+
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
+	// 布隆过滤器判断key一定不存在时直接返回
+	if bf := bpt.filter.Load(); bf != nil && !bf.MayContain(key) {
+		return nil
+	}
+
 	var pos *data.LogRecordPos
 	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte(indexBucketName))
@@ -68,6 +270,10 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 	return pos
 }
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	if bf := bpt.filter.Load(); bf != nil && !bf.MayContain(key) {
+		return nil, false
+	}
+
 	var oldValue []byte
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte(indexBucketName))
@@ -101,7 +307,18 @@ func (bpt *BPlusTree) Size() int {
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBptreeIterator(bpt.tree, reverse)
 }
+
+// Sync 将索引持久化到磁盘
+func (bpt *BPlusTree) Sync() error {
+	return bpt.tree.Sync()
+}
+
 func (bpt *BPlusTree) Close() error {
+	if !bpt.readOnly {
+		if err := bpt.saveBloomFilter(); err != nil {
+			return err
+		}
+	}
 	return bpt.tree.Close()
 }
 
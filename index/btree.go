@@ -35,6 +35,8 @@ func (B BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 
 func (B BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{Key: key}
+	B.lock.RLock()
+	defer B.lock.RUnlock()
 	btreeItem := B.tree.Get(it)
 	if btreeItem == nil {
 		return nil
@@ -72,6 +74,10 @@ func (B BTree) Close() error {
 	return nil
 }
 
+func (B BTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(B, ops)
+}
+
 func newBTreeIterator(tree *btree.BTree, reverse bool) Iterator {
 	var idx int
 	values := make([]*Item, tree.Len())
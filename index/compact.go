@@ -0,0 +1,236 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"github.com/google/btree"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// arena 每次分配的内存块大小
+	arenaChunkSize = 1 << 20
+	// 超过该长度的key单独分配内存，避免浪费arena空间
+	arenaMaxKeySize = arenaChunkSize / 4
+)
+
+// compactPos 内联存储的位置信息，固定16字节
+type compactPos struct {
+	fid    uint32
+	size   uint32
+	offset int64
+}
+
+func newCompactPos(pos *data.LogRecordPos) compactPos {
+	return compactPos{fid: pos.Fid, size: pos.Size, offset: pos.Offset}
+}
+
+func (cp compactPos) logRecordPos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: cp.fid, Offset: cp.offset, Size: cp.size}
+}
+
+// keyArena 将key连续存放在大块内存中，减少小对象的分配
+type keyArena struct {
+	curr      []byte // 当前正在写入的内存块
+	liveBytes int64  // 仍被索引引用的key字节数
+	deadBytes int64  // 已经删除、等待回收的key字节数
+}
+
+// alloc 将key拷贝到arena中，返回指向arena内存的string，不会再单独分配内存
+func (a *keyArena) alloc(key []byte) string {
+	a.liveBytes += int64(len(key))
+	if len(key) == 0 {
+		return ""
+	}
+	if len(key) > arenaMaxKeySize {
+		return string(key)
+	}
+	if cap(a.curr)-len(a.curr) < len(key) {
+		a.curr = make([]byte, 0, arenaChunkSize)
+	}
+	off := len(a.curr)
+	a.curr = append(a.curr, key...)
+	return unsafe.String(&a.curr[off], len(key))
+}
+
+func (a *keyArena) free(key string) {
+	a.liveBytes -= int64(len(key))
+	a.deadBytes += int64(len(key))
+}
+
+// 删除的数据超过一半时需要整理arena
+func (a *keyArena) needCompact() bool {
+	return a.deadBytes > arenaChunkSize && a.deadBytes > a.liveBytes
+}
+
+type compactItem struct {
+	key string // 指向arena中的key数据
+	pos compactPos
+}
+
+func lessCompactItem(a, b compactItem) bool {
+	return a.key < b.key
+}
+
+// 查找时使用的临时item，key直接引用调用方的数据，不做拷贝
+func probeItem(key []byte) compactItem {
+	return compactItem{key: unsafe.String(unsafe.SliceData(key), len(key))}
+}
+
+// CompactBTree 内存优化的BTree索引
+// key统一存放在arena中，位置信息以定长结构内联存储在树节点里，
+// 每个key不再需要单独分配Item、LogRecordPos以及key切片
+type CompactBTree struct {
+	tree  *btree.BTreeG[compactItem]
+	arena *keyArena
+	lock  *sync.RWMutex
+}
+
+// NewCompactBTree 初始化内存优化的BTree索引
+func NewCompactBTree() *CompactBTree {
+	return &CompactBTree{
+		tree:  btree.NewG(32, lessCompactItem),
+		arena: &keyArena{},
+		lock:  new(sync.RWMutex),
+	}
+}
+
+func (cbt *CompactBTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	cbt.lock.Lock()
+	defer cbt.lock.Unlock()
+
+	// key已存在时复用arena中的key，只更新位置信息
+	if oldItem, ok := cbt.tree.Get(probeItem(key)); ok {
+		cbt.tree.ReplaceOrInsert(compactItem{key: oldItem.key, pos: newCompactPos(pos)})
+		return oldItem.pos.logRecordPos()
+	}
+	cbt.tree.ReplaceOrInsert(compactItem{key: cbt.arena.alloc(key), pos: newCompactPos(pos)})
+	return nil
+}
+
+func (cbt *CompactBTree) Get(key []byte) *data.LogRecordPos {
+	cbt.lock.RLock()
+	defer cbt.lock.RUnlock()
+	item, ok := cbt.tree.Get(probeItem(key))
+	if !ok {
+		return nil
+	}
+	return item.pos.logRecordPos()
+}
+
+func (cbt *CompactBTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	cbt.lock.Lock()
+	defer cbt.lock.Unlock()
+	oldItem, ok := cbt.tree.Delete(probeItem(key))
+	if !ok {
+		return nil, false
+	}
+	cbt.arena.free(oldItem.key)
+	if cbt.arena.needCompact() {
+		cbt.compact()
+	}
+	return oldItem.pos.logRecordPos(), true
+}
+
+// compact 将仍然有效的key拷贝到新的arena中，旧的内存块由GC回收
+func (cbt *CompactBTree) compact() {
+	arena := &keyArena{}
+	tree := btree.NewG(32, lessCompactItem)
+	cbt.tree.Ascend(func(item compactItem) bool {
+		tree.ReplaceOrInsert(compactItem{
+			key: arena.alloc(unsafe.Slice(unsafe.StringData(item.key), len(item.key))),
+			pos: item.pos,
+		})
+		return true
+	})
+	cbt.tree = tree
+	cbt.arena = arena
+}
+
+func (cbt *CompactBTree) Size() int {
+	cbt.lock.RLock()
+	defer cbt.lock.RUnlock()
+	return cbt.tree.Len()
+}
+
+// Iterator 基于写时复制的快照进行遍历，不需要拷贝所有数据
+func (cbt *CompactBTree) Iterator(reverse bool) Iterator {
+	// Clone会修改树的写时复制标记，需要加写锁
+	cbt.lock.Lock()
+	tree := cbt.tree.Clone()
+	cbt.lock.Unlock()
+
+	iter := &compactIterator{tree: tree, reverse: reverse}
+	iter.Rewind()
+	return iter
+}
+
+func (cbt *CompactBTree) Close() error {
+	return nil
+}
+
+func (cbt *CompactBTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(cbt, ops)
+}
+
+// CompactBTree 索引迭代器
+type compactIterator struct {
+	tree    *btree.BTreeG[compactItem]
+	reverse bool
+	curr    compactItem
+	valid   bool
+}
+
+func (ci *compactIterator) Rewind() {
+	if ci.reverse {
+		ci.curr, ci.valid = ci.tree.Max()
+	} else {
+		ci.curr, ci.valid = ci.tree.Min()
+	}
+}
+
+func (ci *compactIterator) Seek(key []byte) {
+	ci.seek(probeItem(key), false)
+}
+
+func (ci *compactIterator) Next() {
+	if !ci.valid {
+		return
+	}
+	ci.seek(ci.curr, true)
+}
+
+// seek 定位到第一个大于（反向遍历时小于）等于pivot的位置，skipEqual为true时跳过等于pivot的元素
+func (ci *compactIterator) seek(pivot compactItem, skipEqual bool) {
+	ci.valid = false
+	fn := func(item compactItem) bool {
+		if skipEqual && item.key == pivot.key {
+			return true
+		}
+		ci.curr, ci.valid = item, true
+		return false
+	}
+	if ci.reverse {
+		ci.tree.DescendLessOrEqual(pivot, fn)
+	} else {
+		ci.tree.AscendGreaterOrEqual(pivot, fn)
+	}
+}
+
+func (ci *compactIterator) Valid() bool {
+	return ci.valid
+}
+
+// Key 返回key的拷贝，避免调用方修改arena中的数据
+func (ci *compactIterator) Key() []byte {
+	return []byte(ci.curr.key)
+}
+
+func (ci *compactIterator) Value() *data.LogRecordPos {
+	return ci.curr.pos.logRecordPos()
+}
+
+func (ci *compactIterator) Close() {
+	ci.tree = nil
+	ci.valid = false
+}
@@ -0,0 +1,284 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"runtime"
+	"testing"
+)
+
+func TestCompactBTree_PutGetDelete(t *testing.T) {
+	cbt := NewCompactBTree()
+
+	res1 := cbt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100, Size: 10})
+	assert.Nil(t, res1)
+	pos1 := cbt.Get(nil)
+	assert.Equal(t, uint32(1), pos1.Fid)
+	assert.Equal(t, int64(100), pos1.Offset)
+	assert.Equal(t, uint32(10), pos1.Size)
+
+	key := []byte("a")
+	res2 := cbt.Put(key, &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res2)
+	// 修改调用方的key不会影响索引中的数据
+	key[0] = 'b'
+	assert.Nil(t, cbt.Get([]byte("b")))
+	res3 := cbt.Put([]byte("a"), &data.LogRecordPos{Fid: 11, Offset: 12})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(2), res3.Offset)
+	assert.Equal(t, 2, cbt.Size())
+
+	res4, ok := cbt.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(11), res4.Fid)
+	_, ok = cbt.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Nil(t, cbt.Get([]byte("a")))
+	assert.Equal(t, 1, cbt.Size())
+}
+
+func TestCompactBTree_ArenaCompact(t *testing.T) {
+	cbt := NewCompactBTree()
+	n := 50000
+	for i := 0; i < n; i++ {
+		cbt.Put(testKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	// 删除大部分数据，触发arena整理
+	for i := 0; i < n; i++ {
+		if i%10 != 0 {
+			cbt.Delete(testKey(i))
+		}
+	}
+	assert.True(t, cbt.arena.deadBytes < arenaChunkSize)
+	assert.Equal(t, n/10, cbt.Size())
+	for i := 0; i < n; i += 10 {
+		pos := cbt.Get(testKey(i))
+		assert.NotNil(t, pos)
+		assert.Equal(t, int64(i), pos.Offset)
+	}
+}
+
+func TestCompactBTree_Iterator(t *testing.T) {
+	cbt := NewCompactBTree()
+	bt := NewBTree()
+
+	iter1 := cbt.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	for i := 0; i < 100; i++ {
+		pos := &data.LogRecordPos{Fid: 1, Offset: int64(i)}
+		cbt.Put(testKey(i), pos)
+		bt.Put(testKey(i), pos)
+	}
+	for _, reverse := range []bool{false, true} {
+		iter := cbt.Iterator(reverse)
+		// 迭代器是快照，之后的写入不影响遍历
+		cbt.Put([]byte("zzz"), &data.LogRecordPos{})
+		cbt.Delete(testKey(50))
+		expect := bt.Iterator(reverse)
+		for iter.Rewind(); expect.Valid(); expect.Next() {
+			assert.True(t, iter.Valid())
+			assert.Equal(t, expect.Key(), iter.Key())
+			assert.Equal(t, expect.Value().Offset, iter.Value().Offset)
+			iter.Next()
+		}
+		assert.False(t, iter.Valid())
+		iter.Close()
+		cbt.Delete([]byte("zzz"))
+		cbt.Put(testKey(50), &data.LogRecordPos{Fid: 1, Offset: 50})
+	}
+
+	iter2 := cbt.Iterator(false)
+	iter2.Seek([]byte("bitcask-go-key-000000090"))
+	var count int
+	for ; iter2.Valid(); iter2.Next() {
+		count++
+	}
+	assert.Equal(t, 10, count)
+
+	iter3 := cbt.Iterator(true)
+	iter3.Seek([]byte("bitcask-go-key-0000000095"))
+	assert.Equal(t, testKey(9), iter3.Key())
+}
+
+// 测试用的数据文件，记录每个位置对应的key
+type testKeyFile map[int64][]byte
+
+func (f testKeyFile) resolve(pos *data.LogRecordPos) ([]byte, error) {
+	key, ok := f[pos.Offset]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	return key, nil
+}
+
+func TestKeyHashIndex(t *testing.T) {
+	file := make(testKeyFile)
+	khi := NewKeyHashIndex(file.resolve)
+	put := func(key string, offset int64) *data.LogRecordPos {
+		file[offset] = []byte(key)
+		return khi.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: offset})
+	}
+
+	assert.Nil(t, put("a", 1))
+	assert.Nil(t, put("b", 2))
+	old := put("a", 3)
+	assert.Equal(t, int64(1), old.Offset)
+	assert.Equal(t, int64(3), khi.Get([]byte("a")).Offset)
+	assert.Equal(t, 2, khi.Size())
+
+	old, ok := khi.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), old.Offset)
+	assert.Nil(t, khi.Get([]byte("a")))
+	assert.Equal(t, 1, khi.Size())
+}
+
+func TestKeyHashIndex_Collision(t *testing.T) {
+	file := make(testKeyFile)
+	khi := NewKeyHashIndex(file.resolve)
+	// 所有key的哈希值都相同，通过指纹区分
+	khi.hash = func(key []byte) uint64 { return 1 }
+	khi.fingerprint = func(key []byte) uint16 { return uint16(crc32.ChecksumIEEE(key)) }
+	for i := 0; i < 5; i++ {
+		file[int64(i)] = testKey(i)
+		assert.Nil(t, khi.Put(testKey(i), &data.LogRecordPos{Offset: int64(i)}))
+	}
+	assert.Equal(t, 5, khi.Size())
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, int64(i), khi.Get(testKey(i)).Offset)
+	}
+	assert.Nil(t, khi.Get(testKey(100)))
+
+	// 不存在的key不会误删其他key
+	_, ok := khi.Delete(testKey(100))
+	assert.False(t, ok)
+	_, ok = khi.Delete(testKey(0))
+	assert.True(t, ok)
+	_, ok = khi.Delete(testKey(3))
+	assert.True(t, ok)
+	assert.Equal(t, 3, khi.Size())
+	assert.Nil(t, khi.Get(testKey(0)))
+	assert.Equal(t, int64(4), khi.Get(testKey(4)).Offset)
+
+	iter := khi.Iterator(false)
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{string(testKey(1)), string(testKey(2)), string(testKey(4))}, keys)
+}
+
+// 统计不同索引每个key占用的内存大小，key长度为24字节
+// go test -run TestIndexMemoryPerKey -v ./index
+func TestIndexMemoryPerKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip memory measurement in short mode")
+	}
+	const n = 500000
+	file := make(testKeyFile)
+	newIndexes := []struct {
+		name string
+		new  func() Indexer
+	}{
+		{"btree", func() Indexer { return NewBTree() }},
+		{"art", func() Indexer { return NewART() }},
+		{"compact-btree", func() Indexer { return NewCompactBTree() }},
+		{"keyhash", func() Indexer { return NewKeyHashIndex(file.resolve) }},
+	}
+	for _, ni := range newIndexes {
+		before := heapInUse()
+		idx := ni.new()
+		for i := 0; i < n; i++ {
+			// 模拟数据库写入时每次都是新分配的key
+			key := []byte(fmt.Sprintf("bitcask-go-key-%09d", i))
+			idx.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i), Size: 100})
+		}
+		after := heapInUse()
+		t.Logf("%-14s %6.1f bytes/key", ni.name, float64(after-before)/n)
+		runtime.KeepAlive(idx)
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func TestKeyHashIndex_FullCollision(t *testing.T) {
+	file := make(testKeyFile)
+	var reads int
+	khi := NewKeyHashIndex(func(pos *data.LogRecordPos) ([]byte, error) {
+		reads++
+		return file.resolve(pos)
+	})
+	// 哈希值和指纹都相同，只能读取实际的key区分
+	khi.hash = func(key []byte) uint64 { return 1 }
+	khi.fingerprint = func(key []byte) uint16 { return 7 }
+	for i := 0; i < 5; i++ {
+		file[int64(i)] = testKey(i)
+		assert.Nil(t, khi.Put(testKey(i), &data.LogRecordPos{Offset: int64(i)}))
+	}
+	assert.Equal(t, 5, khi.Size())
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, int64(i), khi.Get(testKey(i)).Offset)
+	}
+
+	file[10] = testKey(2)
+	old := khi.Put(testKey(2), &data.LogRecordPos{Offset: 10})
+	assert.Equal(t, int64(2), old.Offset)
+	assert.Equal(t, int64(10), khi.Get(testKey(2)).Offset)
+	assert.Equal(t, 5, khi.Size())
+
+	_, ok := khi.Delete(testKey(100))
+	assert.False(t, ok)
+	old, ok = khi.Delete(testKey(3))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), old.Offset)
+	assert.Nil(t, khi.Get(testKey(3)))
+	assert.Equal(t, int64(4), khi.Get(testKey(4)).Offset)
+	assert.Equal(t, 4, khi.Size())
+
+	// 只有一个位置的指纹相同时Get不读取数据文件
+	khi2 := NewKeyHashIndex(func(pos *data.LogRecordPos) ([]byte, error) {
+		reads++
+		return file.resolve(pos)
+	})
+	assert.Nil(t, khi2.Put(testKey(0), &data.LogRecordPos{Offset: 0}))
+	reads = 0
+	assert.Equal(t, int64(0), khi2.Get(testKey(0)).Offset)
+	assert.Equal(t, 0, reads)
+}
+
+func TestKeyHashIndex_ResolveError(t *testing.T) {
+	// 读取key失败时无法比较，指纹相同的位置按同一个key处理
+	khi := NewKeyHashIndex(func(pos *data.LogRecordPos) ([]byte, error) {
+		return nil, errors.New("read failed")
+	})
+	khi.hash = func(key []byte) uint64 { return 1 }
+	khi.fingerprint = func(key []byte) uint16 { return uint16(crc32.ChecksumIEEE(key)) }
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, khi.Put(testKey(i), &data.LogRecordPos{Offset: int64(i)}))
+	}
+	old := khi.Put(testKey(2), &data.LogRecordPos{Fid: 1, Offset: MaxKeyHashOffset})
+	assert.Equal(t, int64(2), old.Offset)
+	pos := khi.Get(testKey(2))
+	assert.Equal(t, uint32(1), pos.Fid)
+	assert.Equal(t, int64(MaxKeyHashOffset), pos.Offset)
+	old, ok := khi.Delete(testKey(3))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), old.Offset)
+	assert.Equal(t, 4, khi.Size())
+
+	// 读取key失败时跳过对应的位置，不会panic
+	iter := khi.Iterator(false)
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
@@ -13,9 +13,31 @@ type Indexer interface {
 	Delete(key []byte) (*data.LogRecordPos, bool)
 	Size() int
 	Iterator(reverse bool) Iterator
+	// ApplyBatch 按顺序批量更新索引，返回每个操作对应的旧位置信息
+	ApplyBatch(ops []BatchOp) []*data.LogRecordPos
 	Close() error
 }
 
+// BatchOp 批量更新索引的操作
+type BatchOp struct {
+	Key  []byte
+	Pos  *data.LogRecordPos
+	Type data.LogRecordType // LogRecordDeleted 表示删除key，此时Pos为墓碑记录的位置，不会写入索引
+}
+
+// applyBatch 逐个执行批量操作，用于没有批量优化的内存索引
+func applyBatch(indexer Indexer, ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	for i, op := range ops {
+		if op.Type == data.LogRecordDeleted {
+			oldPositions[i], _ = indexer.Delete(op.Key)
+		} else {
+			oldPositions[i] = indexer.Put(op.Key, op.Pos)
+		}
+	}
+	return oldPositions
+}
+
 type IndexType = int8
 
 const (
@@ -25,6 +47,10 @@ const (
 	ART
 	// BPTree BPTee B+ 树索引
 	BPTree
+	// CompactBtree 内存优化的BTree索引
+	CompactBtree
+	// KeyHash 只存储key哈希值的索引，需要通过 NewKeyHashIndex 创建
+	KeyHash
 )
 
 func NewIndexer(indexType IndexType, dirPath string, syncWrites bool) Indexer {
@@ -35,6 +61,10 @@ func NewIndexer(indexType IndexType, dirPath string, syncWrites bool) Indexer {
 		return NewART()
 	case BPTree:
 		return NewBPlusTree(dirPath, syncWrites)
+	case CompactBtree:
+		return NewCompactBTree()
+	case KeyHash:
+		panic("key hash index needs a key resolver")
 	default:
 		panic("index type not support")
 	}
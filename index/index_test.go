@@ -0,0 +1,49 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestIndexer_ApplyBatch(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bptree-batch")
+	defer os.RemoveAll(dir)
+
+	indexes := map[string]Indexer{
+		"btree":         NewBTree(),
+		"art":           NewART(),
+		"compact-btree": NewCompactBTree(),
+		"sharded":       newTestShardedIndex(4),
+		"bptree":        NewBPlusTree(dir, false),
+	}
+	for name, idx := range indexes {
+		t.Run(name, func(t *testing.T) {
+			idx.Put(testKey(0), &data.LogRecordPos{Fid: 1, Offset: 0})
+			ops := []BatchOp{
+				{Key: testKey(0), Pos: &data.LogRecordPos{Fid: 2, Offset: 0}},
+				{Key: testKey(1), Pos: &data.LogRecordPos{Fid: 2, Offset: 1}},
+				{Key: testKey(2), Pos: &data.LogRecordPos{Fid: 2, Offset: 2}},
+				// 同一批次中的操作按顺序执行
+				{Key: testKey(1), Pos: &data.LogRecordPos{Fid: 2, Offset: 3}},
+				{Key: testKey(2), Pos: &data.LogRecordPos{Fid: 2, Offset: 4}, Type: data.LogRecordDeleted},
+				{Key: testKey(3), Pos: &data.LogRecordPos{Fid: 2, Offset: 5}, Type: data.LogRecordDeleted},
+			}
+			oldPositions := idx.ApplyBatch(ops)
+			assert.Equal(t, len(ops), len(oldPositions))
+			assert.Equal(t, uint32(1), oldPositions[0].Fid)
+			assert.Nil(t, oldPositions[1])
+			assert.Nil(t, oldPositions[2])
+			assert.Equal(t, int64(1), oldPositions[3].Offset)
+			assert.Equal(t, int64(2), oldPositions[4].Offset)
+			assert.Nil(t, oldPositions[5])
+
+			assert.Equal(t, 2, idx.Size())
+			assert.Equal(t, uint32(2), idx.Get(testKey(0)).Fid)
+			assert.Equal(t, int64(3), idx.Get(testKey(1)).Offset)
+			assert.Nil(t, idx.Get(testKey(2)))
+			assert.Nil(t, idx.Close())
+		})
+	}
+}
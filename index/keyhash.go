@@ -0,0 +1,235 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+// KeyResolver 根据位置信息从数据文件中读取记录对应的key
+type KeyResolver func(pos *data.LogRecordPos) ([]byte, error)
+
+// KeyHashIndex 只存储key哈希值的索引，内存占用最小
+// 64位哈希值相同的key先通过16位指纹区分，指纹存放在位置信息中偏移量的高位，新写入的key不需要读取数据文件
+// 更新和删除时哈希值和指纹都相同，需要从数据文件中读取已有的key比较，不同的key同样保存在collisions中
+// Get只有多个位置的指纹相同时才读取key，只有一个位置时直接返回，调用方读取记录时需要校验key
+// 调用方需要保证读取key期间数据文件不会被切换或者删除，DB中所有的索引操作都持有db.mu
+type KeyHashIndex struct {
+	entries     map[uint64]hashEntry
+	collisions  map[uint64][]hashEntry // 哈希值相同的其他key的位置信息，一般为空
+	size        int
+	hash        func(key []byte) uint64
+	fingerprint func(key []byte) uint16
+	resolver    KeyResolver // 读取位置对应的key，遍历时读取失败的位置会被跳过
+	lock        *sync.RWMutex
+}
+
+// 偏移量只使用低48位，高16位存放key的指纹
+const (
+	fingerprintShift = 48
+	hashOffsetMask   = 1<<fingerprintShift - 1
+)
+
+// MaxKeyHashOffset 哈希索引支持的最大数据文件偏移量
+const MaxKeyHashOffset = hashOffsetMask
+
+// hashEntry 和compactPos大小相同的位置信息，offset的高16位为key的指纹
+type hashEntry struct {
+	fid      uint32
+	size     uint32
+	offsetFp uint64
+}
+
+func newHashEntry(pos *data.LogRecordPos, fp uint16) hashEntry {
+	if pos.Offset < 0 || pos.Offset > MaxKeyHashOffset {
+		panic(fmt.Sprintf("offset %d exceeds the key hash index limit", pos.Offset))
+	}
+	return hashEntry{fid: pos.Fid, size: pos.Size, offsetFp: uint64(pos.Offset) | uint64(fp)<<fingerprintShift}
+}
+
+func (he hashEntry) fp() uint16 {
+	return uint16(he.offsetFp >> fingerprintShift)
+}
+
+func (he hashEntry) logRecordPos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: he.fid, Offset: int64(he.offsetFp & hashOffsetMask), Size: he.size}
+}
+
+// NewKeyHashIndex 初始化哈希索引
+func NewKeyHashIndex(resolver KeyResolver) *KeyHashIndex {
+	if resolver == nil {
+		panic("key resolver is nil")
+	}
+	seed, fpSeed := maphash.MakeSeed(), maphash.MakeSeed()
+	return &KeyHashIndex{
+		entries:    make(map[uint64]hashEntry),
+		collisions: make(map[uint64][]hashEntry),
+		hash: func(key []byte) uint64 {
+			return maphash.Bytes(seed, key)
+		},
+		fingerprint: func(key []byte) uint16 {
+			return uint16(maphash.Bytes(fpSeed, key))
+		},
+		resolver: resolver,
+		lock:     new(sync.RWMutex),
+	}
+}
+
+// find 查找key对应的位置，返回下标，-1表示在entries中，-2表示不存在
+// 指纹相同的位置需要读取实际的key比较，verify为false并且只有一个位置的指纹相同时不读取
+func (khi *KeyHashIndex) find(key []byte, h uint64, fp uint16, verify bool) (hashEntry, int) {
+	entry, ok := khi.entries[h]
+	if !ok {
+		return hashEntry{}, -2
+	}
+	others := khi.collisions[h]
+	matched, matchedIdx := 0, -2
+	if entry.fp() == fp {
+		matched, matchedIdx = 1, -1
+	}
+	for i, other := range others {
+		if other.fp() == fp {
+			matched, matchedIdx = matched+1, i
+		}
+	}
+	if matched == 0 {
+		return hashEntry{}, -2
+	}
+	if matched == 1 && !verify {
+		if matchedIdx == -1 {
+			return entry, -1
+		}
+		return others[matchedIdx], matchedIdx
+	}
+
+	sameKey := func(e hashEntry) bool {
+		storedKey, err := khi.resolver(e.logRecordPos())
+		// 读取失败时无法比较，只能按指纹相同当作同一个key
+		return err != nil || bytes.Equal(storedKey, key)
+	}
+	if entry.fp() == fp && sameKey(entry) {
+		return entry, -1
+	}
+	for i, other := range others {
+		if other.fp() == fp && sameKey(other) {
+			return other, i
+		}
+	}
+	return hashEntry{}, -2
+}
+
+func (khi *KeyHashIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	h, fp := khi.hash(key), khi.fingerprint(key)
+	khi.lock.Lock()
+	defer khi.lock.Unlock()
+
+	oldEntry, idx := khi.find(key, h, fp, true)
+	switch idx {
+	case -2:
+		// 哈希值相同但key不同，是另外一个key
+		if _, ok := khi.entries[h]; ok {
+			khi.collisions[h] = append(khi.collisions[h], newHashEntry(pos, fp))
+		} else {
+			khi.entries[h] = newHashEntry(pos, fp)
+		}
+		khi.size++
+		return nil
+	case -1:
+		khi.entries[h] = newHashEntry(pos, fp)
+	default:
+		khi.collisions[h][idx] = newHashEntry(pos, fp)
+	}
+	return oldEntry.logRecordPos()
+}
+
+func (khi *KeyHashIndex) Get(key []byte) *data.LogRecordPos {
+	h, fp := khi.hash(key), khi.fingerprint(key)
+	khi.lock.RLock()
+	defer khi.lock.RUnlock()
+
+	entry, idx := khi.find(key, h, fp, false)
+	if idx == -2 {
+		return nil
+	}
+	return entry.logRecordPos()
+}
+
+func (khi *KeyHashIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	h, fp := khi.hash(key), khi.fingerprint(key)
+	khi.lock.Lock()
+	defer khi.lock.Unlock()
+
+	oldEntry, idx := khi.find(key, h, fp, true)
+	if idx == -2 {
+		return nil, false
+	}
+	others := khi.collisions[h]
+	if idx == -1 {
+		if len(others) == 0 {
+			delete(khi.entries, h)
+		} else {
+			khi.entries[h] = others[0]
+			others = others[1:]
+		}
+	} else {
+		others = append(others[:idx], others[idx+1:]...)
+	}
+	if len(others) == 0 {
+		delete(khi.collisions, h)
+	} else {
+		khi.collisions[h] = others
+	}
+	khi.size--
+	return oldEntry.logRecordPos(), true
+}
+
+func (khi *KeyHashIndex) Size() int {
+	khi.lock.RLock()
+	defer khi.lock.RUnlock()
+	return khi.size
+}
+
+// Iterator 需要从数据文件中读取所有key并排序，开销较大
+// 读取key失败的位置会被跳过，例如数据文件在遍历期间被压缩删除，resolver负责记录错误
+func (khi *KeyHashIndex) Iterator(reverse bool) Iterator {
+	khi.lock.RLock()
+	positions := make([]hashEntry, 0, khi.size)
+	for h, pos := range khi.entries {
+		positions = append(positions, pos)
+		positions = append(positions, khi.collisions[h]...)
+	}
+	khi.lock.RUnlock()
+
+	values := make([]*Item, 0, len(positions))
+	for _, entry := range positions {
+		pos := entry.logRecordPos()
+		key, err := khi.resolver(pos)
+		if err != nil {
+			continue
+		}
+		values = append(values, &Item{Key: key, pos: pos})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(values[i].Key, values[j].Key) > 0
+		}
+		return bytes.Compare(values[i].Key, values[j].Key) < 0
+	})
+
+	return &btreeIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+	}
+}
+
+func (khi *KeyHashIndex) Close() error {
+	return nil
+}
+
+func (khi *KeyHashIndex) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(khi, ops)
+}
@@ -0,0 +1,210 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+// ShardedIndex 分片索引 按key的哈希值将数据分散到多个子索引中
+// 每个子索引各自持有锁，减少并发读写时的锁竞争
+// 每个分片还有一个写入顺序锁，DB可以通过OrderedPut在db.mu之外更新索引，
+// 同一个分片上的更新按照记录在数据文件中的位置顺序生效，读取会等待该分片上还没有完成的更新
+type ShardedIndex struct {
+	shards []Indexer
+	orders []sync.RWMutex // 每个分片的写入顺序锁，更新时持有写锁，读取时持有读锁
+	seed   maphash.Seed
+}
+
+// NewShardedIndex 初始化分片索引，newShard用于创建每个分片的子索引
+func NewShardedIndex(shardNum int, newShard func() Indexer) *ShardedIndex {
+	if shardNum <= 0 {
+		panic("shard num must be greater than 0")
+	}
+	shards := make([]Indexer, shardNum)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+	return &ShardedIndex{
+		shards: shards,
+		orders: make([]sync.RWMutex, shardNum),
+		seed:   maphash.MakeSeed(),
+	}
+}
+
+// 根据key找到对应分片的下标
+func (si *ShardedIndex) shardIndex(key []byte) int {
+	h := maphash.Bytes(si.seed, key)
+	return int(h % uint64(len(si.shards)))
+}
+
+func (si *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	i := si.shardIndex(key)
+	si.orders[i].Lock()
+	defer si.orders[i].Unlock()
+	return si.shards[i].Put(key, pos)
+}
+
+// OrderedPut 调用时需要持有外部写锁，并且pos对应的记录已经写入数据文件
+// 只锁住key所在分片的写入顺序就返回，释放外部写锁之后再调用返回的函数完成更新
+// 写入顺序锁在外部写锁中获取，所以同一个分片上的更新按照记录的位置顺序生效，后写入的位置不会被先写入的覆盖
+// fn在释放写入顺序锁之前以key原来的位置调用，没有原来的位置时为nil
+func (si *ShardedIndex) OrderedPut(key []byte, pos *data.LogRecordPos) func(fn func(oldPos *data.LogRecordPos)) {
+	i := si.shardIndex(key)
+	si.orders[i].Lock()
+	return func(fn func(oldPos *data.LogRecordPos)) {
+		defer si.orders[i].Unlock()
+		fn(si.shards[i].Put(key, pos))
+	}
+}
+
+// Get 等待该分片上已经开始的更新完成之后再读取，保证读取到的是数据文件中最新的位置
+func (si *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	i := si.shardIndex(key)
+	si.orders[i].RLock()
+	defer si.orders[i].RUnlock()
+	return si.shards[i].Get(key)
+}
+
+func (si *ShardedIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	i := si.shardIndex(key)
+	si.orders[i].Lock()
+	defer si.orders[i].Unlock()
+	return si.shards[i].Delete(key)
+}
+
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+func (si *ShardedIndex) Iterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		si.orders[i].RLock()
+		iters[i] = shard.Iterator(reverse)
+		si.orders[i].RUnlock()
+	}
+	return newShardedIterator(iters, reverse)
+}
+
+func (si *ShardedIndex) Close() error {
+	var firstErr error
+	for i, shard := range si.shards {
+		// 等待还没有完成的更新
+		si.orders[i].Lock()
+		err := shard.Close()
+		si.orders[i].Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ApplyBatch 按分片拆分操作，每个分片内保持原有的顺序
+func (si *ShardedIndex) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	shardOps := make(map[int][]BatchOp)
+	shardIdx := make(map[int][]int)
+	for i, op := range ops {
+		idx := si.shardIndex(op.Key)
+		shardOps[idx] = append(shardOps[idx], op)
+		shardIdx[idx] = append(shardIdx[idx], i)
+	}
+
+	// 按分片下标的顺序获取写入顺序锁，避免多个批次之间死锁
+	idxs := make([]int, 0, len(shardOps))
+	for idx := range shardOps {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	for _, idx := range idxs {
+		si.orders[idx].Lock()
+	}
+	defer func() {
+		for _, idx := range idxs {
+			si.orders[idx].Unlock()
+		}
+	}()
+
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	for idx, sops := range shardOps {
+		for i, oldPos := range si.shards[idx].ApplyBatch(sops) {
+			oldPositions[shardIdx[idx][i]] = oldPos
+		}
+	}
+	return oldPositions
+}
+
+// 分片索引迭代器 对各个分片的迭代器做多路归并，保证整体有序
+type shardedIterator struct {
+	iters   []Iterator
+	reverse bool
+	curr    int // 当前key所在的子迭代器下标，-1表示遍历结束
+}
+
+func newShardedIterator(iters []Iterator, reverse bool) *shardedIterator {
+	si := &shardedIterator{
+		iters:   iters,
+		reverse: reverse,
+	}
+	si.pick()
+	return si
+}
+
+// pick 从所有子迭代器当前位置中选出最小（反向遍历时为最大）的key
+func (si *shardedIterator) pick() {
+	si.curr = -1
+	for i, iter := range si.iters {
+		if !iter.Valid() {
+			continue
+		}
+		if si.curr == -1 {
+			si.curr = i
+			continue
+		}
+		cmp := bytes.Compare(iter.Key(), si.iters[si.curr].Key())
+		if (!si.reverse && cmp < 0) || (si.reverse && cmp > 0) {
+			si.curr = i
+		}
+	}
+}
+
+func (si *shardedIterator) Rewind() {
+	for _, iter := range si.iters {
+		iter.Rewind()
+	}
+	si.pick()
+}
+func (si *shardedIterator) Seek(key []byte) {
+	for _, iter := range si.iters {
+		iter.Seek(key)
+	}
+	si.pick()
+}
+func (si *shardedIterator) Next() {
+	if si.curr == -1 {
+		return
+	}
+	si.iters[si.curr].Next()
+	si.pick()
+}
+func (si *shardedIterator) Valid() bool {
+	return si.curr != -1
+}
+func (si *shardedIterator) Key() []byte {
+	return si.iters[si.curr].Key()
+}
+func (si *shardedIterator) Value() *data.LogRecordPos {
+	return si.iters[si.curr].Value()
+}
+func (si *shardedIterator) Close() {
+	for _, iter := range si.iters {
+		iter.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("bitcask-go-key-%09d", i))
+}
+
+func newTestShardedIndex(shardNum int) *ShardedIndex {
+	return NewShardedIndex(shardNum, func() Indexer {
+		return NewBTree()
+	})
+}
+
+func TestShardedIndex_PutGetDelete(t *testing.T) {
+	si := newTestShardedIndex(8)
+
+	res1 := si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res1)
+	res2 := si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res2.Offset)
+
+	pos := si.Get([]byte("a"))
+	assert.Equal(t, uint32(1), pos.Fid)
+	assert.Equal(t, int64(3), pos.Offset)
+	assert.Nil(t, si.Get([]byte("not-exist")))
+
+	for i := 0; i < 100; i++ {
+		si.Put(testKey(i), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+	}
+	assert.Equal(t, 101, si.Size())
+
+	oldPos, ok := si.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), oldPos.Offset)
+	_, ok = si.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, 100, si.Size())
+}
+
+func TestShardedIndex_Iterator(t *testing.T) {
+	si := newTestShardedIndex(4)
+	bt := NewBTree()
+
+	// 1.索引为空的情况
+	iter1 := si.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	// 2.多个分片中的数据归并后与单个BTree的顺序一致
+	for i := 0; i < 200; i++ {
+		pos := &data.LogRecordPos{Fid: 1, Offset: int64(i)}
+		si.Put(testKey(i), pos)
+		bt.Put(testKey(i), pos)
+	}
+	for _, reverse := range []bool{false, true} {
+		iter := si.Iterator(reverse)
+		expect := bt.Iterator(reverse)
+		for iter.Rewind(); expect.Valid(); expect.Next() {
+			assert.True(t, iter.Valid())
+			assert.Equal(t, expect.Key(), iter.Key())
+			assert.Equal(t, expect.Value().Offset, iter.Value().Offset)
+			iter.Next()
+		}
+		assert.False(t, iter.Valid())
+		iter.Close()
+		expect.Close()
+	}
+
+	// 3.测试 seek
+	iter2 := si.Iterator(false)
+	iter2.Seek(testKey(150))
+	var count int
+	var prev []byte
+	for ; iter2.Valid(); iter2.Next() {
+		assert.True(t, prev == nil || bytes.Compare(prev, iter2.Key()) < 0)
+		prev = iter2.Key()
+		count++
+	}
+	assert.Equal(t, 50, count)
+
+	// 4.反向遍历的 seek
+	iter3 := si.Iterator(true)
+	iter3.Seek(testKey(49))
+	count = 0
+	for ; iter3.Valid(); iter3.Next() {
+		count++
+	}
+	assert.Equal(t, 50, count)
+}
+
+// 使用 go test -race 运行，检查并发读写时的数据竞争
+func TestShardedIndex_Concurrent(t *testing.T) {
+	si := newTestShardedIndex(16)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := testKey(g*1000 + i)
+				si.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.NotNil(t, si.Get(key))
+				if i%3 == 0 {
+					si.Delete(key)
+				}
+			}
+		}(g)
+	}
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				iter := si.Iterator(false)
+				for iter.Rewind(); iter.Valid(); iter.Next() {
+					_ = iter.Value()
+				}
+				iter.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 8*(1000-334), si.Size())
+}
+
+func TestShardedIndex_OrderedPut(t *testing.T) {
+	si := newTestShardedIndex(4)
+	key := []byte("a")
+	si.Put(key, &data.LogRecordPos{Fid: 1, Offset: 1})
+
+	apply := si.OrderedPut(key, &data.LogRecordPos{Fid: 1, Offset: 2})
+	// 还没有完成的更新会阻塞同一个分片上的读取以及之后的更新
+	got := make(chan *data.LogRecordPos)
+	go func() {
+		got <- si.Get(key)
+	}()
+	updated := make(chan *data.LogRecordPos)
+	go func() {
+		updated <- si.Put(key, &data.LogRecordPos{Fid: 1, Offset: 3})
+	}()
+	select {
+	case <-got:
+		t.Fatal("get returned before the ordered put was applied")
+	case <-updated:
+		t.Fatal("put applied before the ordered put")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var oldPos *data.LogRecordPos
+	apply(func(pos *data.LogRecordPos) {
+		oldPos = pos
+	})
+	assert.Equal(t, int64(1), oldPos.Offset)
+	assert.Equal(t, int64(2), (<-updated).Offset)
+	<-got
+	assert.Equal(t, int64(3), si.Get(key).Offset)
+}
+
+func BenchmarkShardedIndex_PutGet(b *testing.B) {
+	indexes := map[string]Indexer{
+		"btree":   NewBTree(),
+		"sharded": newTestShardedIndex(16),
+	}
+	for name, idx := range indexes {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					key := testKey(i)
+					idx.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+					idx.Get(key)
+					i++
+				}
+			})
+		})
+	}
+}
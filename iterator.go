@@ -12,7 +12,14 @@ type Iterator struct {
 }
 
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	return db.newIterator(db.index, opts)
+}
+
+func (db *DB) newIterator(indexer index.Indexer, opts IteratorOptions) *Iterator {
+	// 哈希索引创建迭代器时需要从数据文件中读取key，持有读锁避免数据文件同时被切换或者删除
+	db.mu.RLock()
+	indexIter := indexer.Iterator(opts.Reverse)
+	db.mu.RUnlock()
 
 	return &Iterator{
 		db:        db,
@@ -2,22 +2,50 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"bitcask-go/utils"
+	"context"
+	"errors"
 	"io"
 	"os"
-	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 )
 
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+	// 旧的数据文件已经删除，正在将merge之后的文件移动到数据目录中
+	mergeSwappingFileName = "merge-swapping"
+	// 每处理多少字节汇报一次merge进度
+	mergeProgressInterval = 1024 * 1024
+	// 使用O_DIRECT写入merge文件时缓冲区的大小
+	directWriteBufferSize = 1024 * 1024
 )
 
 // Merge 清理无效数据 生成Hint文件
 func (db *DB) Merge() error {
+	return db.MergeWithOptions(context.Background(), DefaultMergeOptions)
+}
+
+// MergeCtx 清理无效数据 生成Hint文件，ctx结束时放弃本次merge，返回ContextError
+func (db *DB) MergeCtx(ctx context.Context) error {
+	return db.MergeWithOptions(ctx, DefaultMergeOptions)
+}
+
+// MergeWithOptions 清理无效数据 生成Hint文件
+// ctx结束时放弃本次merge并删除merge目录，返回ContextError，可以通过配置限制merge写入的速度，以及获取merge的进度
+func (db *DB) MergeWithOptions(ctx context.Context, opts MergeOptions) (err error) {
+	if opts.RateLimitBytesPerSec < 0 {
+		return errors.New("invalid merge rate limit, must not be negative")
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
 	// 如果数据库为空 直接返回
 	if db.activeFile == nil {
 		return nil
@@ -36,41 +64,48 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return err
 	}
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	db.garbageMu.Lock()
+	reclaimSize := db.reclaimSize
+	db.garbageMu.Unlock()
+	if float32(reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
 	}
 
-	//  查看剩余空间容量是否可以容纳merge之后的数据量
-	availableDiskSize, err := utils.AvailableDiskSize()
+	//  查看merge目录所在的文件系统剩余空间是否可以容纳merge之后的数据量
+	availableDiskSize, err := utils.AvailableDiskSize(filepath.Dir(db.getMergePath()))
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
+	if uint64(totalSize-reclaimSize) >= availableDiskSize {
 		db.mu.Unlock()
 		return ErrNoEnoughSpaceForMerge
 	}
 
 	db.isMerge = true
 	defer func() {
+		db.mergeDone.Store(0)
+		db.mergeTotal.Store(0)
 		db.isMerge = false
 	}()
 
 	// 持久化当前活跃文件
-	err = db.activeFile.Sync()
+	err = db.syncActiveFile()
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
 
 	// 将当前活跃文件转换为旧数据文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	oldFile := db.activeFile
+	db.olderFiles[oldFile.FileId] = oldFile
 	// 打开新活跃文件
 	if err := db.setActiveDataFile(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
+	db.fileRotated(oldFile)
 
 	// 记录最新的没有参与merge的文件id 用作后续系统启动时候使用
 	nonMergeFileId := db.activeFile.FileId
@@ -80,12 +115,44 @@ func (db *DB) Merge() error {
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
+	// merge期间创建的命名空间只会写入新的活跃文件
+	indexes := db.namespaceIndexes()
 	db.mu.Unlock()
 
 	// 将待merge的文件进行排序
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
+
+	// 统计需要处理的数据量，用于汇报merge进度
+	var mergeTotal int64
+	for _, dataFile := range mergeFiles {
+		size, err := dataFile.IOManager.Size()
+		if err != nil {
+			return err
+		}
+		mergeTotal += size
+	}
+	db.mergeTotal.Store(mergeTotal)
+
+	info := MergeInfo{FileNum: len(mergeFiles), TotalSize: mergeTotal}
+	db.logger.Info("merge begin", "dir", db.options.DirPath, "files", info.FileNum, "size", info.TotalSize)
+	if fn := db.options.EventListener.OnMergeBegin; fn != nil {
+		fn(info)
+	}
+	start := time.Now()
+	defer func() {
+		info.Duration, info.Err = time.Since(start), err
+		if err != nil {
+			db.logger.Error("merge failed", "dir", db.options.DirPath, "duration", info.Duration, "err", err)
+		} else {
+			db.logger.Info("merge end", "dir", db.options.DirPath, "duration", info.Duration)
+		}
+		if fn := db.options.EventListener.OnMergeEnd; fn != nil {
+			fn(info)
+		}
+	}()
+
 	mergePath := db.getMergePath()
 	// 如果目录存在 说明发生过merge 将其删除
 	if _, err = os.Stat(mergePath); err == nil {
@@ -97,25 +164,68 @@ func (db *DB) Merge() error {
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
 		return err
 	}
+	// merge没有完成时删除merge目录，避免重启时加载不完整的数据
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(mergePath)
+		}
+	}()
 
 	// 打开一个临时bitcask实例
 	mergeoptions := db.options
 	mergeoptions.DirPath = mergePath
+	mergeoptions.MergeDir = ""
 	mergeoptions.SyncWrites = false
+	mergeoptions.IndexSnapshotInterval = 0
+	mergeoptions.BloomFilterFPRate = 0
+	// merge用于释放空间，不受磁盘限制
+	mergeoptions.MaxDiskBytes = 0
+	mergeoptions.LowDiskWatermark = 0
+	mergeoptions.DiskQuotaCallback = nil
+	// 临时实例的文件切换以及关闭不通知用户，也不输出日志
+	mergeoptions.EventListener = EventListener{}
+	mergeoptions.Logger = nil
 	mergeDB, err := Open(mergeoptions)
 	if err != nil {
 		return err
 	}
+	mergeDB.directWrites = db.options.MergeDirectIO
+	defer func() {
+		if closeErr := mergeDB.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
 
 	// 打开hint文件存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+
+	var limiter *utils.RateLimiter
+	if opts.RateLimitBytesPerSec > 0 {
+		limiter = utils.NewRateLimiter(opts.RateLimitBytesPerSec)
+	}
+	var mergeDone, lastReport int64
+	reportProgress := func() {
+		db.mergeDone.Store(mergeDone)
+		if opts.Progress != nil {
+			opts.Progress(mergeDone, mergeTotal)
+		}
+		lastReport = mergeDone
+	}
+
 	// 遍历处理每个数据文件
+	indexOps := make([]index.BatchOp, 0, indexBatchSize)
 	for _, dataFile := range mergeFiles {
+		// 顺序读取整个文件，处理完成之后不再占用页缓存
+		_ = dataFile.Advise(fio.AdviceSequential)
 		var offset int64 = 0
 		for {
+			if err := checkContext(ctx, "merge"); err != nil {
+				return err
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
@@ -123,29 +233,59 @@ func (db *DB) Merge() error {
 				}
 				return err
 			}
-			// 解析拿到实际的key
+			// 解析拿到命名空间以及实际的key
+			nsId := splitNamespace(logRecord)
 			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
+			var logRecordPos *data.LogRecordPos
+			if idx := indexes[nsId]; idx != nil {
+				logRecordPos = db.indexGet(idx, realKey)
+			}
 			// 和内存中索引位置进行比较，如果有效则重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
 				logRecordPos.Offset == offset {
+				// 限制写入速度
+				if limiter != nil {
+					if err := limiter.WaitN(ctx, int(size)); err != nil {
+						return &ContextError{Op: "merge", Err: err}
+					}
+				}
+				// 操作数合并为完整的值，旧的数据文件删除之后操作数链将失效
+				if err := db.collapseMergeOperand(realKey, logRecord); err != nil {
+					return err
+				}
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				joinNamespace(logRecord, nsId)
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
 					return err
 				}
 				// 将当前位置索引写入到Hint文件当中
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				if err := hintFile.Write(encodeNamespaceHintRecord(nsId, realKey, pos, data.LogRecordNormal)); err != nil {
 					return err
 				}
+				// B+树索引存储在磁盘上，重写之后的位置写入到merge目录的索引中，和数据文件一起替换
+				if mergeDB.options.IndexType == BPlusTree && nsId == defaultNamespaceId {
+					indexOps = append(indexOps, index.BatchOp{Key: realKey, Pos: pos, Type: data.LogRecordNormal})
+					if len(indexOps) == indexBatchSize {
+						mergeDB.index.ApplyBatch(indexOps)
+						indexOps = indexOps[:0]
+					}
+				}
 			}
 
 			// 增加offset
 			offset += size
+			mergeDone += size
+			if mergeDone-lastReport >= mergeProgressInterval {
+				reportProgress()
+			}
 		}
+		_ = dataFile.Advise(fio.AdviceDontNeed)
 	}
+	reportProgress()
+	mergeDB.index.ApplyBatch(indexOps)
 
 	// sync 保证持久化
 	if err := hintFile.Sync(); err != nil {
@@ -154,12 +294,22 @@ func (db *DB) Merge() error {
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	if bpt, ok := mergeDB.index.(*index.BPlusTree); ok {
+		if err := bpt.Sync(); err != nil {
+			return err
+		}
+	}
+	// 写入完成标识之前检查是否已经取消
+	if err := checkContext(ctx, "merge"); err != nil {
+		return err
+	}
 
 	// 写标识merge完成的文件
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer mergeFinishedFile.Close()
 	// record中存入最新未merge的活动文件id
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
@@ -173,79 +323,132 @@ func (db *DB) Merge() error {
 		return err
 	}
 
+	// 重建布隆过滤器，清除已删除key的影响
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		return bpt.RebuildBloomFilter()
+	}
+
 	return nil
 }
 
-// 在windows上会出错，filepath.Join(dir, base+mergeDirName)会拼接出./C:....这样的地址，而os.ReadDir读取这样的地址会报错
+// getMergePath merge目录，默认为数据目录同级的 <数据目录名>-merge 目录
 func (db *DB) getMergePath() string {
-	//dir := path.Dir(path.Clean(db.options.DirPath))
-	base := path.Base(db.options.DirPath)
-	//return filepath.Join(dir, base+mergeDirName)
-	return base + mergeDirName
+	if db.options.MergeDir != "" {
+		return db.options.MergeDir
+	}
+	dirPath := filepath.Clean(db.options.DirPath)
+	return filepath.Join(filepath.Dir(dirPath), filepath.Base(dirPath)+mergeDirName)
 }
 
-// 加载merge数据目录
-func (db *DB) loadMergeFiles() error {
+// 加载merge数据目录，返回是否使用了merge之后的数据文件
+// 替换的过程可以重复执行：旧的数据文件全部删除之后写入mergeSwappingFileName标识，之后只需要继续移动剩余的文件
+// 文件全部移动之后merge目录由Open在索引加载完成后删除，B+树索引回放merge期间写入的数据之前崩溃，重启时仍然会回放
+func (db *DB) loadMergeFiles() (bool, error) {
 	mergePath := db.getMergePath()
 	// merge目录不存在直接返回
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
-		return nil
+		return false, nil
 	}
-	defer func() {
-		_ = os.RemoveAll(mergePath)
-	}()
 
 	dirEntries, err := os.ReadDir(mergePath)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// 查找标识merge完成的文件，判断merge是否完成
-	var mergeFinished bool
+	var mergeFinished, swapping bool
 	var mergeFileNames []string
 	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName {
+		switch entry.Name() {
+		case data.MergeFinishedFileName:
 			mergeFinished = true
-		}
-		if entry.Name() == data.SeqNoFileName {
 			continue
-		}
-		if entry.Name() == fileLockName {
+		case mergeSwappingFileName:
+			swapping = true
+			continue
+		case data.SeqNoFileName, fileLockName, index.BloomFilterFileName:
 			continue
 		}
 		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
-	// 没有merge完成则直接返回
-	if !mergeFinished {
-		return nil
+	// 只读时不替换数据文件，merge完成之前旧的数据文件仍然完整
+	if db.options.ReadOnly {
+		if swapping {
+			return false, errors.New("merge files are being swapped, open in read-write mode first")
+		}
+		return false, nil
 	}
-
-	// 获取最新未merge的文件id
-	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
-	if err != nil {
-		return nil
+	// 没有merge完成则直接删除merge目录，已经开始替换时标识merge完成的文件可能已经移动
+	if !mergeFinished && !swapping {
+		return false, os.RemoveAll(mergePath)
 	}
 
-	// 删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+	if !swapping {
+		// 获取最新未merge的文件id
+		nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+		if err != nil {
+			db.logger.Error("read merge finished file failed", "dir", mergePath, "err", err)
+			return false, err
+		}
+
+		// 删除旧的数据文件
+		var fileId uint32 = 0
+		for ; fileId < nonMergeFileId; fileId++ {
+			fileName := data.GetDataFileName(db.options.DirPath, fileId)
+			if _, err := os.Stat(fileName); err == nil {
+				if err := os.Remove(fileName); err != nil {
+					return false, err
+				}
+			}
+			// 文件压缩生成的hint文件，对应的数据文件已经被删除
+			hintFileName := data.GetDataHintFileName(db.options.DirPath, fileId)
+			if err := os.Remove(hintFileName); err != nil && !os.IsNotExist(err) {
+				return false, err
 			}
 		}
+		// 数据文件已经被重写，索引快照以及布隆过滤器失效
+		if err := db.removeIndexSnapshot(); err != nil {
+			return false, err
+		}
+		bloomFileName := filepath.Join(db.options.DirPath, index.BloomFilterFileName)
+		if err := os.Remove(bloomFileName); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+
+		// 旧的数据文件已经全部删除，之后重启不能再次删除，否则会删掉已经移动过去的文件
+		swappingFile, err := os.Create(filepath.Join(mergePath, mergeSwappingFileName))
+		if err != nil {
+			return false, err
+		}
+		if err := swappingFile.Sync(); err != nil {
+			_ = swappingFile.Close()
+			return false, err
+		}
+		if err := swappingFile.Close(); err != nil {
+			return false, err
+		}
+	}
+
+	// 将新的数据文件移动到数据目录中，标识merge完成的文件最后移动
+	fileNum := len(mergeFileNames)
+	if mergeFinished {
+		mergeFileNames = append(mergeFileNames, data.MergeFinishedFileName)
 	}
-	// 将新的数据文件移动到数据目录中
 	for _, fileName := range mergeFileNames {
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
-			return err
+		// merge目录可能在其他的文件系统中，无法直接重命名时拷贝文件
+		if err := utils.MoveFile(srcPath, destPath); err != nil {
+			return false, err
 		}
 	}
+	// 持久化数据目录中的重命名，之后才会删除merge目录
+	if err := utils.SyncDir(db.options.DirPath); err != nil {
+		return false, err
+	}
 
-	return nil
+	db.logger.Info("merge files loaded", "dir", db.options.DirPath, "files", fileNum)
+	return true, nil
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
@@ -253,6 +456,7 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
+	defer mergeFinishedFile.Close()
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
 		return 0, err
@@ -273,13 +477,15 @@ func (db *DB) loadIndexFromHintFile() error {
 	}
 
 	// 打开hint索引文件
-	hintFile, err := data.OpenHintFile(hintFileName)
+	hintFile, err := data.OpenReadOnlyFile(hintFileName)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
 
-	// 读取文件中的索引
+	// 读取文件中的索引，批量写入到对应命名空间的索引中
 	var offset int64 = 0
+	batches := db.newIndexBatches(nil)
 	for {
 		record, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -288,10 +494,12 @@ func (db *DB) loadIndexFromHintFile() error {
 			}
 			return err
 		}
-		// 解码拿到实际的位置索引
+		// 解码拿到实际的位置索引，已经被删除的命名空间直接跳过
+		nsId := splitNamespace(record)
 		pos := data.DecodeLogRecordPos(record.Value)
-		db.index.Put(record.Key, pos)
+		batches.add(nsId, index.BatchOp{Key: record.Key, Pos: pos, Type: data.LogRecordNormal})
 		offset += size
 	}
+	batches.flush()
 	return nil
 }
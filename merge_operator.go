@@ -0,0 +1,198 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"context"
+	"encoding/binary"
+)
+
+// 一个key最多连续写入多少个操作数，超过之后合并为完整的值，避免读取时需要遍历过长的链
+const maxMergeOperands = 64
+
+// MergeOperator 合并操作符，将写入的操作数依次合并到已有的值上，例如计数器累加、集合求并集等
+type MergeOperator interface {
+	// FullMerge existing为key之前的值，key不存在时为nil，operands按照写入的顺序排列
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// Int64AddOperator 将8字节大端序的int64累加，与Increment使用相同的编码
+type Int64AddOperator struct{}
+
+func (Int64AddOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if existing != nil {
+		if len(existing) != 8 {
+			return nil, ErrValueNotInteger
+		}
+		sum = int64(binary.BigEndian.Uint64(existing))
+	}
+	for _, operand := range operands {
+		if len(operand) != 8 {
+			return nil, ErrValueNotInteger
+		}
+		sum += int64(binary.BigEndian.Uint64(operand))
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(sum))
+	return buf, nil
+}
+
+// MergeValue 写入key的一个操作数，读取时通过Options.MergeOperator与之前的值合并
+// 操作数记录中保存了上一条记录的位置，同一个key的操作数只会在同一个数据文件中串联，
+// 上一条记录在其他的数据文件中时直接合并为完整的值写入，merge和文件压缩重写数据时也会合并为完整的值
+func (db *DB) MergeValue(key, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.MergeOperator == nil {
+		return ErrMergeOperatorMissing
+	}
+
+	return db.retryOnDiskQuota(context.Background(), "merge value", func() error {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.mergeValue(key, operand)
+	})
+}
+
+// mergeValue 写入key的一个操作数，需要持有db.mu
+func (db *DB) mergeValue(key, operand []byte) error {
+	var prevPos *data.LogRecordPos
+	var prevRecord *data.LogRecord
+	if pos := db.index.Get(key); pos != nil {
+		logRecord, err := db.getLogRecordByPosition(pos)
+		if err != nil {
+			return err
+		}
+		// 哈希索引只比较哈希值，实际的key不同说明key不存在
+		if realKey, _ := parseLogRecordKey(logRecord.Key); string(realKey) == string(key) {
+			prevPos, prevRecord = pos, logRecord
+		}
+	}
+
+	var depth uint64 = 1
+	if prevRecord != nil && prevRecord.Type == data.LogRecordMergeOperand {
+		depth, _, _ = decodeMergeOperand(prevRecord.Value)
+		depth++
+	}
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: encodeMergeOperand(depth, prevPos, operand),
+		Type:  data.LogRecordMergeOperand,
+	}
+
+	// 上一条记录不在当前活跃文件中，或者写入时需要切换活跃文件，或者操作数过多时，合并为完整的值
+	if prevPos != nil {
+		_, size := data.EncodeLogRecord(logRecord)
+		if db.activeFile == nil || prevPos.Fid != db.activeFile.FileId || depth > maxMergeOperands ||
+			db.activeFile.WriteOff+size >= db.options.DataFileSize {
+			existing, err := db.resolveValue(key, prevRecord)
+			if err != nil {
+				return err
+			}
+			value, err := db.options.MergeOperator.FullMerge(key, existing, [][]byte{operand})
+			if err != nil {
+				return err
+			}
+			return db.putValue(key, value)
+		}
+	}
+
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.addReclaimSize(db.index.Put(key, pos))
+
+	// 二级索引需要根据合并之后完整的值提取term
+	if len(db.secondaryIndexes) > 0 {
+		value, err := db.resolveValue(key, logRecord)
+		if err != nil {
+			return err
+		}
+		db.updateSecondaryIndexes(key, value)
+	}
+	return nil
+}
+
+// collapseMergeOperand 重写数据时将操作数记录合并为完整的值
+func (db *DB) collapseMergeOperand(key []byte, logRecord *data.LogRecord) error {
+	if logRecord.Type != data.LogRecordMergeOperand {
+		return nil
+	}
+	db.mu.RLock()
+	value, err := db.resolveValue(key, logRecord)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	logRecord.Value = value
+	logRecord.Type = data.LogRecordNormal
+	return nil
+}
+
+// resolveValue 得到记录对应的完整的值，操作数记录需要沿着链读取之前的记录并合并
+func (db *DB) resolveValue(key []byte, logRecord *data.LogRecord) ([]byte, error) {
+	if logRecord.Type != data.LogRecordMergeOperand {
+		return logRecord.Value, nil
+	}
+	if db.options.MergeOperator == nil {
+		return nil, ErrMergeOperatorMissing
+	}
+
+	var operands [][]byte
+	var existing []byte
+	for logRecord != nil {
+		if logRecord.Type != data.LogRecordMergeOperand {
+			if logRecord.Type == data.LogRecordNormal {
+				existing = logRecord.Value
+			}
+			break
+		}
+		_, prevPos, operand := decodeMergeOperand(logRecord.Value)
+		operands = append(operands, operand)
+		if prevPos == nil {
+			break
+		}
+		prevRecord, err := db.getLogRecordByPosition(prevPos)
+		if err != nil {
+			return nil, err
+		}
+		logRecord = prevRecord
+	}
+
+	// 按照写入的顺序排列操作数
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+	return db.options.MergeOperator.FullMerge(key, existing, operands)
+}
+
+// 操作数记录的value：链的长度 + 上一条记录位置的长度 + 上一条记录的位置 + 操作数
+func encodeMergeOperand(depth uint64, prevPos *data.LogRecordPos, operand []byte) []byte {
+	var encPos []byte
+	if prevPos != nil {
+		encPos = data.EncodeLogRecordPos(prevPos)
+	}
+	buf := make([]byte, binary.MaxVarintLen64*2+len(encPos)+len(operand))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], depth)
+	index += binary.PutUvarint(buf[index:], uint64(len(encPos)))
+	index += copy(buf[index:], encPos)
+	index += copy(buf[index:], operand)
+	return buf[:index]
+}
+
+func decodeMergeOperand(buf []byte) (uint64, *data.LogRecordPos, []byte) {
+	var index = 0
+	depth, n := binary.Uvarint(buf[index:])
+	index += n
+	posLen, n := binary.Uvarint(buf[index:])
+	index += n
+	var prevPos *data.LogRecordPos
+	if posLen > 0 {
+		prevPos = data.DecodeLogRecordPos(buf[index : index+int(posLen)])
+	}
+	index += int(posLen)
+	return depth, prevPos, buf[index:]
+}
@@ -0,0 +1,126 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// 按写入顺序用逗号拼接操作数
+type concatOperator struct{}
+
+func (concatOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	parts := operands
+	if existing != nil {
+		parts = append([][]byte{existing}, operands...)
+	}
+	return bytes.Join(parts, []byte(",")), nil
+}
+
+func TestDB_MergeValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-operator")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrMergeOperatorMissing, db.MergeValue([]byte("key"), []byte("a")))
+	assert.Nil(t, db.Close())
+
+	opts.MergeOperator = concatOperator{}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.MergeValue([]byte("key"), []byte("a")))
+	assert.Nil(t, db.MergeValue([]byte("key"), []byte("b")))
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a,b"), val)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("base")))
+	assert.Nil(t, db.MergeValue([]byte("key"), []byte("c")))
+	val, err = db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("base,c"), val)
+
+	assert.Nil(t, db.Delete([]byte("key")))
+	assert.Nil(t, db.MergeValue([]byte("key"), []byte("d")))
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, []byte("d"), value)
+		return true
+	}))
+
+	// 超过操作数链的长度限制时合并为完整的值
+	var expected [][]byte
+	for i := 0; i < maxMergeOperands*3; i++ {
+		operand := []byte(fmt.Sprintf("%d", i))
+		expected = append(expected, operand)
+		assert.Nil(t, db.MergeValue([]byte("long"), operand))
+	}
+	val, err = db.Get([]byte("long"))
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Join(expected, []byte(",")), val)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get([]byte("long"))
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Join(expected, []byte(",")), val)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_MergeValueRewrite(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-operator")
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(dir + mergeDirName)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.DataFileMergeRatio = 0.1
+	opts.MergeOperator = Int64AddOperator{}
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	operand := make([]byte, 8)
+	binary.BigEndian.PutUint64(operand, 1)
+	// 操作数分布在多个数据文件中
+	for n := 0; n < 20; n++ {
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, db.MergeValue(utils.GetTestKey(i), operand))
+		}
+		assert.Nil(t, db.Put(utils.GetTestKey(1000+n), utils.RandomValue(256)))
+	}
+	n, err := db.Increment(utils.GetTestKey(0), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), n)
+
+	check := func(db *DB) {
+		for i := 0; i < 50; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			expected := int64(20)
+			if i == 0 {
+				expected = 30
+			}
+			assert.Equal(t, expected, int64(binary.BigEndian.Uint64(val)))
+		}
+	}
+	check(db)
+
+	// 文件压缩以及merge重写数据之后，操作数链合并为完整的值
+	assert.Nil(t, db.CompactFiles(2))
+	check(db)
+	assert.Nil(t, db.Merge())
+	check(db)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Close())
+}
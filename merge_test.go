@@ -0,0 +1,301 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func prepareMergeDB(t *testing.T, indexType IndexerType) (*DB, Options) {
+	opts := DefaultOptions
+	opts.IndexType = indexType
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge")
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+		_ = os.RemoveAll(dir + mergeDirName)
+	})
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0.1
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		}
+	}
+	return db, opts
+}
+
+func TestDB_MergeWithOptions(t *testing.T) {
+	db, _ := prepareMergeDB(t, BTree)
+	defer db.Close()
+
+	var lastDone, lastTotal int64
+	var stat *Stat
+	start := time.Now()
+	err := db.MergeWithOptions(context.Background(), MergeOptions{
+		RateLimitBytesPerSec: 100 * 1024,
+		Progress: func(done, total int64) {
+			assert.GreaterOrEqual(t, done, lastDone)
+			lastDone, lastTotal = done, total
+			stat = db.Stat()
+		},
+	})
+	assert.Nil(t, err)
+	// 有效数据约160KB，限速100KB/s，至少需要等待0.5秒以上
+	assert.Greater(t, time.Since(start), time.Millisecond*500)
+	assert.Greater(t, lastTotal, int64(0))
+	assert.Equal(t, lastTotal, lastDone)
+	assert.Equal(t, lastTotal, stat.MergeTotal)
+	assert.Equal(t, lastDone, stat.MergeDone)
+	assert.Equal(t, int64(0), db.Stat().MergeTotal)
+}
+
+func TestDB_MergeWithOptionsCancel(t *testing.T) {
+	db, opts := prepareMergeDB(t, BTree)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err := db.MergeWithOptions(ctx, MergeOptions{RateLimitBytesPerSec: 10 * 1024})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var ctxErr *ContextError
+	assert.ErrorAs(t, err, &ctxErr)
+	assert.Equal(t, "merge", ctxErr.Op)
+	assert.False(t, db.isMerge)
+	assert.Equal(t, int64(0), db.Stat().MergeTotal)
+
+	// 取消后merge目录被删除
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, db.Put([]byte("after-cancel"), []byte("v")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_MergeReopen(t *testing.T) {
+	for name, indexType := range map[string]IndexerType{"btree": BTree, "bptree": BPlusTree} {
+		t.Run(name, func(t *testing.T) {
+			db, opts := prepareMergeDB(t, indexType)
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			}
+			assert.Nil(t, db.Merge())
+
+			// merge之后继续写入，这部分数据不在merge的结果中
+			for i := 900; i < 1100; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("after-merge")))
+			}
+			assert.Nil(t, db.Delete(utils.GetTestKey(500)))
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			assert.Nil(t, wb.Put(utils.GetTestKey(600), []byte("batch")))
+			assert.Nil(t, wb.Delete(utils.GetTestKey(700)))
+			assert.Nil(t, wb.Commit())
+			assert.Nil(t, db.Close())
+
+			check := func(db *DB) {
+				assert.Equal(t, 998, len(db.ListKeys()))
+				for i := 0; i < 1100; i++ {
+					val, err := db.Get(utils.GetTestKey(i))
+					switch {
+					case i < 100 || i == 500 || i == 700:
+						assert.Equal(t, ErrKeyNotFound, err)
+					case i == 600:
+						assert.Equal(t, []byte("batch"), val)
+					case i >= 900:
+						assert.Equal(t, []byte("after-merge"), val)
+					default:
+						assert.Nil(t, err, i)
+					}
+				}
+			}
+
+			// 第一次打开时替换merge之后的文件
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			_, err = os.Stat(db.getMergePath())
+			assert.True(t, os.IsNotExist(err))
+			check(db)
+			assert.Nil(t, db.Put(utils.GetTestKey(100), []byte("reopen")))
+			assert.Nil(t, db.Close())
+
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			val, err := db.Get(utils.GetTestKey(100))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("reopen"), val)
+			check(db)
+			assert.Nil(t, db.Close())
+		})
+	}
+}
+
+func TestDB_MergePath(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/a/bitcask"
+	db := &DB{options: opts}
+	assert.Equal(t, filepath.Clean("/tmp/a/bitcask-merge"), db.getMergePath())
+
+	// 不同父目录下同名的数据目录不会冲突
+	opts.DirPath = "/tmp/b/bitcask/"
+	db = &DB{options: opts}
+	assert.Equal(t, filepath.Clean("/tmp/b/bitcask-merge"), db.getMergePath())
+
+	opts.MergeDir = "/tmp/merge"
+	db = &DB{options: opts}
+	assert.Equal(t, "/tmp/merge", db.getMergePath())
+
+	opts.MergeDir = opts.DirPath
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
+
+// 在另一个文件系统中进行merge，替换文件时无法直接重命名
+func TestDB_MergeDirCrossDevice(t *testing.T) {
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		t.Skip("no tmpfs available")
+	}
+	mergeDir, err := os.MkdirTemp("/dev/shm", "bitcask-go-merge")
+	assert.Nil(t, err)
+	defer os.RemoveAll(mergeDir)
+	mergeDir = filepath.Join(mergeDir, "merge")
+
+	db, opts := prepareMergeDB(t, BTree)
+	db.options.MergeDir = mergeDir
+	opts.MergeDir = mergeDir
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(mergeDir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+// 模拟替换merge文件的过程中退出，重启之后可以继续完成替换
+func TestDB_LoadMergeFilesCrash(t *testing.T) {
+	crashes := map[string]func(t *testing.T, dirPath, mergePath string){
+		// merge没有完成，不会使用merge目录中的文件
+		"merge-unfinished": func(t *testing.T, dirPath, mergePath string) {
+			assert.Nil(t, os.Remove(filepath.Join(mergePath, data.MergeFinishedFileName)))
+		},
+		// 只删除了部分旧的数据文件
+		"deleting": func(t *testing.T, dirPath, mergePath string) {
+			assert.Nil(t, os.Remove(data.GetDataFileName(dirPath, 0)))
+		},
+		// 旧的数据文件已经删除，只移动了部分merge之后的文件
+		"swapping": func(t *testing.T, dirPath, mergePath string) {
+			db := &DB{options: Options{DirPath: dirPath}}
+			nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+			assert.Nil(t, err)
+			for fid := uint32(0); fid < nonMergeFileId; fid++ {
+				_ = os.Remove(data.GetDataFileName(dirPath, fid))
+			}
+			file, err := os.Create(filepath.Join(mergePath, mergeSwappingFileName))
+			assert.Nil(t, err)
+			assert.Nil(t, file.Close())
+			for _, fid := range []uint32{0, 1} {
+				assert.Nil(t, os.Rename(data.GetDataFileName(mergePath, fid), data.GetDataFileName(dirPath, fid)))
+			}
+		},
+		// 所有文件都已经移动，还没有加载merge期间写入的数据
+		"replaying": func(t *testing.T, dirPath, mergePath string) {
+			db := &DB{options: Options{DirPath: dirPath}}
+			nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+			assert.Nil(t, err)
+			for fid := uint32(0); fid < nonMergeFileId; fid++ {
+				_ = os.Remove(data.GetDataFileName(dirPath, fid))
+			}
+			file, err := os.Create(filepath.Join(mergePath, mergeSwappingFileName))
+			assert.Nil(t, err)
+			assert.Nil(t, file.Close())
+			entries, err := os.ReadDir(mergePath)
+			assert.Nil(t, err)
+			for _, entry := range entries {
+				switch entry.Name() {
+				case mergeSwappingFileName, data.SeqNoFileName, fileLockName:
+					continue
+				}
+				assert.Nil(t, os.Rename(filepath.Join(mergePath, entry.Name()), filepath.Join(dirPath, entry.Name())))
+			}
+		},
+	}
+
+	for _, indexType := range []IndexerType{BTree, BPlusTree} {
+		for name, crash := range crashes {
+			t.Run(fmt.Sprintf("%d-%s", indexType, name), func(t *testing.T) {
+				db, opts := prepareMergeDB(t, indexType)
+				for i := 0; i < 100; i++ {
+					assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+				}
+				assert.Nil(t, db.Merge())
+				assert.Nil(t, db.Put([]byte("after-merge"), []byte("v")))
+				assert.Nil(t, db.Close())
+
+				crash(t, opts.DirPath, db.getMergePath())
+
+				db, err := Open(opts)
+				assert.Nil(t, err)
+				_, err = os.Stat(db.getMergePath())
+				assert.True(t, os.IsNotExist(err))
+				assert.Equal(t, 901, len(db.ListKeys()))
+				for i := 100; i < 1000; i++ {
+					_, err := db.Get(utils.GetTestKey(i))
+					assert.Nil(t, err)
+				}
+				val, err := db.Get([]byte("after-merge"))
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("v"), val)
+				assert.Nil(t, db.Close())
+			})
+		}
+	}
+}
+
+func TestDB_MergeDirectIO(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-direct")
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(dir + mergeDirName)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0.1
+	opts.MergeDirectIO = true
+	opts.PreallocateSize = 16 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	values := make(map[int][]byte)
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 1000; i++ {
+			values[i] = utils.RandomValue(128)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		}
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,418 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// defaultNamespaceId 默认命名空间，DB本身的读写操作都在默认命名空间中
+const defaultNamespaceId uint32 = 0
+
+// Namespace 命名空间，所有命名空间共享数据文件，每个命名空间拥有独立的索引
+type Namespace struct {
+	db        *DB
+	id        uint32
+	name      string
+	indexType IndexerType
+	index     index.Indexer
+	dropped   bool // 是否已经被删除，需要持有db.mu访问
+}
+
+// Namespace 获取命名空间，不存在时使用默认配置创建
+func (db *DB) Namespace(name string) (*Namespace, error) {
+	return db.NamespaceWithOptions(name, DefaultNamespaceOptions)
+}
+
+// NamespaceWithOptions 获取命名空间，不存在时按照opts创建，已经存在时忽略opts
+func (db *DB) NamespaceWithOptions(name string, opts NamespaceOptions) (*Namespace, error) {
+	if name == "" {
+		return nil, ErrNamespaceNameIsEmpty
+	}
+	// 启动时只会从B+树中加载默认命名空间的索引，命名空间的内存索引无法恢复
+	if opts.IndexType == BPlusTree || db.options.IndexType == BPlusTree {
+		return nil, ErrNamespaceIndexUnsupported
+	}
+	if opts.IndexType < BTree || opts.IndexType > KeyHash {
+		return nil, errors.New("invalid namespace index type")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if ns, ok := db.namespaces[name]; ok {
+		return ns, nil
+	}
+
+	// 先持久化命名空间的元数据，之后才能写入属于这个命名空间的数据
+	id := db.nextNamespaceId
+	if err := db.writeNamespaceRecord(&data.LogRecord{
+		Key:   []byte(name),
+		Value: encodeNamespaceMeta(id, opts.IndexType),
+		Type:  data.LogRecordNormal,
+	}); err != nil {
+		return nil, err
+	}
+	db.nextNamespaceId++
+
+	return db.addNamespace(id, name, opts.IndexType)
+}
+
+// DropNamespace 删除命名空间，只需要写入一条删除记录，数据文件中的数据在merge时回收
+func (db *DB) DropNamespace(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ns, ok := db.namespaces[name]
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+	if err := db.writeNamespaceRecord(&data.LogRecord{
+		Key:   []byte(name),
+		Value: encodeNamespaceMeta(ns.id, ns.indexType),
+		Type:  data.LogRecordDeleted,
+	}); err != nil {
+		return err
+	}
+
+	// 命名空间中的数据全部失效
+	iterator := ns.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		db.addReclaimSize(iterator.Value())
+	}
+	iterator.Close()
+
+	ns.dropped = true
+	delete(db.namespaces, name)
+	delete(db.namespaceIds, ns.id)
+	return ns.index.Close()
+}
+
+// ListNamespaces 获取所有命名空间的名称
+func (db *DB) ListNamespaces() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	names := make([]string, 0, len(db.namespaces))
+	for name := range db.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name 命名空间的名称
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Put 写入Key Value，Key不能为空
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	return ns.db.retryOnDiskQuota(context.Background(), "put", func() error {
+		ns.db.mu.Lock()
+		defer ns.db.mu.Unlock()
+
+		if ns.dropped {
+			return ErrNamespaceDropped
+		}
+		return ns.db.putValueIn(ns.id, ns.index, key, value)
+	})
+}
+
+// Get 读取key对应的value
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+
+	if ns.dropped {
+		return nil, ErrNamespaceDropped
+	}
+	return ns.db.getValueIn(ns.index, key)
+}
+
+// Delete 删除key
+func (ns *Namespace) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+
+	if ns.dropped {
+		return ErrNamespaceDropped
+	}
+	return ns.db.deleteValueIn(ns.id, ns.index, key)
+}
+
+// NewIterator 遍历命名空间中的数据
+func (ns *Namespace) NewIterator(opts IteratorOptions) *Iterator {
+	return ns.db.newIterator(ns.index, opts)
+}
+
+// ListKeys 获取命名空间中所有key
+func (ns *Namespace) ListKeys() [][]byte {
+	return ns.db.listKeys(ns.index)
+}
+
+// loadNamespaces 加载所有命名空间，需要在加载索引之前完成
+func (db *DB) loadNamespaces() error {
+	db.nextNamespaceId = defaultNamespaceId + 1
+	fileName := filepath.Join(db.options.DirPath, data.NamespaceFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	nsFile, err := data.OpenReadOnlyFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer nsFile.Close()
+
+	// 依次回放创建和删除记录，得到当前存在的命名空间
+	type namespaceMeta struct {
+		id        uint32
+		indexType IndexerType
+	}
+	metas := make(map[string]namespaceMeta)
+	var offset int64 = 0
+	for {
+		record, size, err := nsFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		id, indexType := decodeNamespaceMeta(record.Value)
+		// 删除之后的id也不能再次使用，数据文件中可能还有属于它的数据
+		if id >= db.nextNamespaceId {
+			db.nextNamespaceId = id + 1
+		}
+		if record.Type == data.LogRecordDeleted {
+			delete(metas, string(record.Key))
+		} else {
+			metas[string(record.Key)] = namespaceMeta{id: id, indexType: indexType}
+		}
+		offset += size
+	}
+
+	for name, meta := range metas {
+		if _, err := db.addNamespace(meta.id, name, meta.indexType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNamespace 创建命名空间的索引并注册
+func (db *DB) addNamespace(id uint32, name string, indexType IndexerType) (*Namespace, error) {
+	nsOptions := db.options
+	nsOptions.IndexType = indexType
+	nsIndex, err := newIndexer(nsOptions, db.readKeyByPosition)
+	if err != nil {
+		return nil, err
+	}
+	ns := &Namespace{
+		db:        db,
+		id:        id,
+		name:      name,
+		indexType: indexType,
+		index:     nsIndex,
+	}
+	db.namespaces[name] = ns
+	db.namespaceIds[id] = ns
+	return ns, nil
+}
+
+// writeNamespaceRecord 追加写入命名空间的元数据并持久化，需要持有db.mu
+func (db *DB) writeNamespaceRecord(record *data.LogRecord) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.namespaceFile == nil {
+		nsFile, err := data.OpenNamespaceFile(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		db.namespaceFile = nsFile
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := db.namespaceFile.Write(encRecord); err != nil {
+		return err
+	}
+	return db.namespaceFile.Sync()
+}
+
+// namespaceIndex 命名空间对应的索引，命名空间不存在或者已经被删除时返回nil
+func (db *DB) namespaceIndex(nsId uint32) index.Indexer {
+	if nsId == defaultNamespaceId {
+		return db.index
+	}
+	if ns, ok := db.namespaceIds[nsId]; ok {
+		return ns.index
+	}
+	return nil
+}
+
+// namespaceIndexes 所有命名空间的索引，包括默认命名空间，需要持有db.mu
+// merge和文件压缩在不持有锁的情况下读取，命名空间删除之后内存索引仍然可以读取
+func (db *DB) namespaceIndexes() map[uint32]index.Indexer {
+	indexes := make(map[uint32]index.Indexer, len(db.namespaceIds)+1)
+	indexes[defaultNamespaceId] = db.index
+	for id, ns := range db.namespaceIds {
+		indexes[id] = ns.index
+	}
+	return indexes
+}
+
+// resetIndexes 重新创建所有命名空间的索引
+func (db *DB) resetIndexes() error {
+	if err := db.index.Close(); err != nil {
+		return err
+	}
+	var err error
+	if db.index, err = newIndexer(db.options, db.readKeyByPosition); err != nil {
+		return err
+	}
+	for _, ns := range db.namespaceIds {
+		if err := ns.index.Close(); err != nil {
+			return err
+		}
+		nsOptions := db.options
+		nsOptions.IndexType = ns.indexType
+		if ns.index, err = newIndexer(nsOptions, db.readKeyByPosition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeNamespaces 关闭命名空间的索引以及元数据文件
+func (db *DB) closeNamespaces() error {
+	for _, ns := range db.namespaceIds {
+		if err := ns.index.Close(); err != nil {
+			return err
+		}
+	}
+	if db.namespaceFile != nil {
+		return db.namespaceFile.Close()
+	}
+	return nil
+}
+
+// indexBatches 按命名空间分别暂存索引的更新，攒够一批之后批量更新对应的索引
+type indexBatches struct {
+	db  *DB
+	ops map[uint32][]index.BatchOp
+	// 每个操作更新到索引之后调用，oldPos为被覆盖或者删除的旧位置，可以为nil
+	applied func(op index.BatchOp, oldPos *data.LogRecordPos)
+}
+
+func (db *DB) newIndexBatches(applied func(op index.BatchOp, oldPos *data.LogRecordPos)) *indexBatches {
+	return &indexBatches{
+		db:      db,
+		ops:     make(map[uint32][]index.BatchOp),
+		applied: applied,
+	}
+}
+
+// add 暂存一个操作，命名空间不存在或者已经被删除时返回false
+func (b *indexBatches) add(nsId uint32, op index.BatchOp) bool {
+	idx := b.db.namespaceIndex(nsId)
+	if idx == nil {
+		return false
+	}
+	ops := append(b.ops[nsId], op)
+	if len(ops) == indexBatchSize {
+		b.apply(idx, ops)
+		ops = ops[:0]
+	}
+	b.ops[nsId] = ops
+	return true
+}
+
+// flush 更新所有暂存的操作
+func (b *indexBatches) flush() {
+	for nsId, ops := range b.ops {
+		if idx := b.db.namespaceIndex(nsId); idx != nil && len(ops) > 0 {
+			b.apply(idx, ops)
+		}
+		b.ops[nsId] = ops[:0]
+	}
+}
+
+func (b *indexBatches) apply(idx index.Indexer, ops []index.BatchOp) {
+	oldPositions := idx.ApplyBatch(ops)
+	if b.applied == nil {
+		return
+	}
+	for i, oldPos := range oldPositions {
+		b.applied(ops[i], oldPos)
+	}
+}
+
+// joinNamespace 将记录标记为属于nsId命名空间，在key的最前面加上命名空间id，默认命名空间的记录保持不变
+func joinNamespace(logRecord *data.LogRecord, nsId uint32) {
+	if nsId == defaultNamespaceId {
+		return
+	}
+	buf := make([]byte, binary.MaxVarintLen32+len(logRecord.Key))
+	n := binary.PutUvarint(buf, uint64(nsId))
+	n += copy(buf[n:], logRecord.Key)
+	logRecord.Key = buf[:n]
+	logRecord.Type |= data.LogRecordNamespaceFlag
+}
+
+// splitNamespace 去掉记录中的命名空间标记以及key中的命名空间id，返回命名空间id
+func splitNamespace(logRecord *data.LogRecord) uint32 {
+	if logRecord.Type&data.LogRecordNamespaceFlag == 0 {
+		return defaultNamespaceId
+	}
+	logRecord.Type &^= data.LogRecordNamespaceFlag
+	nsId, n := binary.Uvarint(logRecord.Key)
+	logRecord.Key = logRecord.Key[n:]
+	return uint32(nsId)
+}
+
+// encodeNamespaceHintRecord 编码hint记录，非默认命名空间的key带上命名空间id
+func encodeNamespaceHintRecord(nsId uint32, key []byte, pos *data.LogRecordPos, recordType data.LogRecordType) []byte {
+	record := &data.LogRecord{Key: key, Type: recordType}
+	joinNamespace(record, nsId)
+	return data.EncodeHintRecordWithType(record.Key, pos, record.Type)
+}
+
+// batchKey WriteBatch中暂存数据的key，不同命名空间中相同的key互不影响
+func batchKey(nsId uint32, key []byte) string {
+	buf := make([]byte, binary.MaxVarintLen32+len(key))
+	n := binary.PutUvarint(buf, uint64(nsId))
+	n += copy(buf[n:], key)
+	return string(buf[:n])
+}
+
+// 命名空间元数据：命名空间id + 索引类型
+func encodeNamespaceMeta(id uint32, indexType IndexerType) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+1)
+	n := binary.PutUvarint(buf, uint64(id))
+	buf[n] = byte(indexType)
+	return buf[:n+1]
+}
+
+func decodeNamespaceMeta(buf []byte) (uint32, IndexerType) {
+	id, n := binary.Uvarint(buf)
+	return uint32(id), IndexerType(buf[n])
+}
@@ -0,0 +1,191 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Namespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	users, err := db.Namespace("users")
+	assert.Nil(t, err)
+	orders, err := db.NamespaceWithOptions("orders", NamespaceOptions{IndexType: ART})
+	assert.Nil(t, err)
+	_, err = db.NamespaceWithOptions("bptree", NamespaceOptions{IndexType: BPlusTree})
+	assert.Equal(t, ErrNamespaceIndexUnsupported, err)
+
+	// 不同命名空间中相同的key互不影响
+	key := utils.GetTestKey(1)
+	assert.Nil(t, db.Put(key, []byte("default")))
+	assert.Nil(t, users.Put(key, []byte("user")))
+	assert.Nil(t, orders.Put(key, []byte("order")))
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, users.Put(utils.GetTestKey(i+100), utils.RandomValue(64)))
+	}
+	assert.Nil(t, orders.Delete(key))
+	_, err = orders.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Equal(t, 1001, len(users.ListKeys()))
+
+	// 再次获取返回同一个命名空间
+	users2, err := db.Namespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, users, users2)
+	assert.Equal(t, []string{"orders", "users"}, db.ListNamespaces())
+
+	iter := users.NewIterator(IteratorOptions{Prefix: []byte("bitcask-go-key-00000010")})
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 10, count)
+	assert.Nil(t, db.Close())
+
+	// 重启之后从数据文件中恢复每个命名空间的索引
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders", "users"}, db.ListNamespaces())
+	users, err = db.Namespace("users")
+	assert.Nil(t, err)
+	orders, err = db.Namespace("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, ART, orders.indexType)
+	val, err = users.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	assert.Equal(t, 1001, len(users.ListKeys()))
+	assert.Equal(t, 0, len(orders.ListKeys()))
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_NamespaceWriteBatch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	users, err := db.Namespace("users")
+	assert.Nil(t, err)
+	orders, err := db.Namespace("orders")
+	assert.Nil(t, err)
+	assert.Nil(t, orders.Put([]byte("order-1"), []byte("v")))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k"), []byte("default")))
+	assert.Nil(t, wb.PutIn(users, []byte("k"), []byte("user")))
+	assert.Nil(t, wb.DeleteIn(orders, []byte("order-1")))
+	// 没有提交之前不可见
+	_, err = users.Get([]byte("k"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, wb.Commit())
+
+	val, err := users.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	val, err = db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	_, err = orders.Get([]byte("order-1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 命名空间被删除之后整个批次都不能提交
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("default")))
+	assert.Nil(t, wb.PutIn(orders, []byte("order-2"), []byte("v")))
+	assert.Nil(t, db.DropNamespace("orders"))
+	assert.Equal(t, ErrNamespaceDropped, wb.Commit())
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	users, err = db.Namespace("users")
+	assert.Nil(t, err)
+	val, err = users.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_DropNamespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0.1
+	opts.IndexSnapshotInterval = time.Hour
+	defer os.RemoveAll(dir + mergeDirName)
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	logs, err := db.Namespace("logs")
+	assert.Nil(t, err)
+	users, err := db.NamespaceWithOptions("users", NamespaceOptions{IndexType: KeyHash})
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, logs.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		assert.Nil(t, users.Put(utils.GetTestKey(i), []byte("user")))
+	}
+	reclaimSize := db.reclaimSize
+	assert.Nil(t, db.DropNamespace("logs"))
+	assert.Greater(t, db.reclaimSize, reclaimSize)
+	assert.Equal(t, ErrNamespaceNotFound, db.DropNamespace("logs"))
+	assert.Equal(t, ErrNamespaceDropped, logs.Put([]byte("k"), []byte("v")))
+	_, err = logs.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrNamespaceDropped, err)
+
+	// 同名的命名空间重新创建之后使用新的id，看不到之前的数据
+	logs, err = db.Namespace("logs")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(logs.ListKeys()))
+	assert.Nil(t, logs.Put([]byte("k"), []byte("v")))
+
+	// merge之后命名空间中的数据仍然有效，被删除的命名空间中的数据被回收
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"logs", "users"}, db.ListNamespaces())
+	logs, err = db.Namespace("logs")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logs.ListKeys()))
+	users, err = db.Namespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(users.ListKeys()))
+	val, err := users.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	assert.Nil(t, db.Close())
+
+	// 从索引快照中恢复命名空间的索引
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db.lastSnapshot)
+	users, err = db.Namespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(users.ListKeys()))
+	assert.Nil(t, db.Close())
+}
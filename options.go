@@ -1,5 +1,7 @@
 package bitcask_go
 
+import "time"
+
 type Options struct {
 	DirPath            string
 	DataFileSize       int64       //数据文件大小
@@ -8,6 +10,44 @@ type Options struct {
 	IndexType          IndexerType // 索引类型
 	MMapAtStartup      bool        // 启动时是否使用MMap加载数据
 	DataFileMergeRatio float32     // 数据文件合并的阈值
+	IndexShardNum      int         // 内存索引分片数量，大于1时按key哈希分片，Put在db.mu之外按分片更新索引，减少读写之间的锁竞争
+	BloomFilterFPRate  float64     // B+树索引布隆过滤器的误判率，为0时不开启
+	// 后台生成内存索引快照的间隔，为0时不开启，重启时加载快照只需要回放之后写入的数据
+	IndexSnapshotInterval time.Duration
+	LoadIndexWorkers      int    // 启动时并发读取数据文件的goroutine数量
+	MergeDir              string // merge时使用的临时目录，为空时使用数据目录同级的 <数据目录名>-merge 目录
+	// 合并操作符，使用MergeValue写入操作数时必须指定
+	MergeOperator MergeOperator
+	// 按数据位置缓存读取到的记录，最多占用的字节数，为0时不开启
+	ValueCacheSize int64
+	// 活跃文件写缓冲的字节数，多次写入合并为一次系统调用，缓冲区写满或者Sync时写入文件，为0时不开启
+	// 没有Sync的数据在进程崩溃时会丢失，而不开启时只有操作系统崩溃才会丢失
+	WriteBufferSize int
+	// 活跃文件写入时每次预分配的磁盘空间大小，不改变文件大小，减少文件系统分配空间的元数据更新，为0时不预分配
+	// 只在Linux上生效，数据文件关闭时释放没有使用的部分
+	PreallocateSize int64
+	// merge时使用O_DIRECT写入新的数据文件，不经过页缓存，避免挤占应用的页缓存，只在Linux上生效
+	MergeDirectIO bool
+	// 读取数据文件时使用io_uring，GetMany可以一次提交同一个数据文件中的所有读取，实验性，只在Linux上生效，内核不支持时使用标准文件IO
+	// 提交之后同步等待整个批次完成，所有文件共用一个io_uring实例，并发的批次依次执行
+	UseIOUring bool
+	// 数据目录最多占用的字节数，超过之后写入返回ErrDiskQuotaExceeded，删除不受限制，为0时不限制
+	MaxDiskBytes int64
+	// 数据目录所在文件系统的剩余空间低于该值时，写入返回ErrDiskQuotaExceeded，为0时不检查
+	LowDiskWatermark uint64
+	// 超过磁盘限制时写入最多等待的时间，期间merge或者文件压缩释放空间之后继续写入，为0时直接返回错误，空间释放之后的写入不受影响
+	DiskQuotaStallTimeout time.Duration
+	// 磁盘限制事件的回调，可能在持有数据库锁时调用，回调中不能读写数据库
+	DiskQuotaCallback func(event DiskQuotaEvent)
+	// 日志输出，可以直接使用*slog.Logger，为nil时不输出日志
+	Logger Logger
+	// 数据库事件的回调
+	EventListener EventListener
+	// 持久化活跃文件的耗时超过该值时输出日志并调用EventListener.OnSyncSlow，为0时不检查
+	SlowSyncThreshold time.Duration
+	// 以只读方式打开，写入、merge以及文件压缩返回ErrReadOnly，所有文件都以只读方式打开，不创建文件锁以及活跃文件
+	// 多个只读实例可以同时打开同一个数据目录；B+树索引需要索引文件已经存在；只读时不使用io_uring
+	ReadOnly bool
 }
 
 type IteratorOptions struct {
@@ -23,6 +63,26 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// MergeOptions merge配置
+type MergeOptions struct {
+	// 每秒最多写入多少字节，为0时不限速
+	RateLimitBytesPerSec int64
+	// merge进度回调，done为已经处理的旧数据文件字节数，total为需要处理的总字节数
+	Progress func(done, total int64)
+}
+
+// GetManyOptions 批量读取配置
+type GetManyOptions struct {
+	// 同时读取的数据文件数量，小于等于1时按顺序依次读取
+	Concurrency int
+}
+
+// NamespaceOptions 命名空间配置
+type NamespaceOptions struct {
+	// 命名空间使用的索引类型，不支持B+树索引
+	IndexType IndexerType
+}
+
 type IndexerType = int8
 
 const (
@@ -34,16 +94,41 @@ const (
 
 	// BPlusTree B+树索引，将索引存储到磁盘上
 	BPlusTree
+
+	// CompactBTree 内存优化的BTree索引，key存放在arena中，位置信息内联存储
+	CompactBTree
+
+	// KeyHash 只存储key的哈希值，内存占用最小，但遍历时需要从数据文件中读取key
+	KeyHash
 )
 
 var DefaultOptions = Options{
-	DirPath:            "./data",
-	DataFileSize:       1024 * 1024 * 1024, // 1G
-	SyncWrites:         false,
-	IndexType:          BTree,
-	BytesPerSync:       0,
-	MMapAtStartup:      true,
-	DataFileMergeRatio: 0.5,
+	DirPath:               "./data",
+	DataFileSize:          1024 * 1024 * 1024, // 1G
+	SyncWrites:            false,
+	IndexType:             BTree,
+	BytesPerSync:          0,
+	MMapAtStartup:         true,
+	DataFileMergeRatio:    0.5,
+	IndexShardNum:         0,
+	BloomFilterFPRate:     0.01,
+	IndexSnapshotInterval: 0,
+	LoadIndexWorkers:      4,
+	MergeDir:              "",
+	MergeOperator:         nil,
+	ValueCacheSize:        0,
+	WriteBufferSize:       0,
+	PreallocateSize:       0,
+	MergeDirectIO:         false,
+	UseIOUring:            false,
+	MaxDiskBytes:          0,
+	LowDiskWatermark:      0,
+	DiskQuotaStallTimeout: 0,
+	DiskQuotaCallback:     nil,
+	Logger:                nil,
+	EventListener:         EventListener{},
+	SlowSyncThreshold:     time.Second,
+	ReadOnly:              false,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -51,6 +136,19 @@ var DefaultIteratorOptions = IteratorOptions{
 	Reverse: false,
 }
 
+var DefaultMergeOptions = MergeOptions{
+	RateLimitBytesPerSec: 0,
+	Progress:             nil,
+}
+
+var DefaultGetManyOptions = GetManyOptions{
+	Concurrency: 1,
+}
+
+var DefaultNamespaceOptions = NamespaceOptions{
+	IndexType: BTree,
+}
+
 var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
@@ -0,0 +1,168 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/btree"
+)
+
+// IndexExtractor 从一条数据中提取二级索引的term，一条数据可以对应多个term，返回空时不建立索引
+type IndexExtractor func(key, value []byte) [][]byte
+
+// secondaryIndex 二级索引，维护term到主键的映射，只保存在内存中，需要持有db.mu访问
+type secondaryIndex struct {
+	extractor IndexExtractor
+	entries   *btree.BTreeG[secondaryEntry] // 按照term、主键的顺序排列
+	terms     map[string][][]byte           // 每个主键当前对应的term，更新和删除时用于清除旧的term
+}
+
+type secondaryEntry struct {
+	term []byte
+	key  []byte
+}
+
+func lessSecondaryEntry(a, b secondaryEntry) bool {
+	if c := bytes.Compare(a.term, b.term); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+func newSecondaryIndex(extractor IndexExtractor) *secondaryIndex {
+	return &secondaryIndex{
+		extractor: extractor,
+		entries:   btree.NewG(32, lessSecondaryEntry),
+		terms:     make(map[string][][]byte),
+	}
+}
+
+// update key写入了新的value，重新提取term
+func (si *secondaryIndex) update(key, value []byte) {
+	si.remove(key)
+	terms := si.extractor(key, value)
+	if len(terms) == 0 {
+		return
+	}
+	// 用户传入的key以及提取出的term可能被复用，需要拷贝一份
+	key = append([]byte(nil), key...)
+	stored := make([][]byte, 0, len(terms))
+	for _, term := range terms {
+		term = append([]byte(nil), term...)
+		si.entries.ReplaceOrInsert(secondaryEntry{term: term, key: key})
+		stored = append(stored, term)
+	}
+	si.terms[string(key)] = stored
+}
+
+// remove key被删除，清除它对应的所有term
+func (si *secondaryIndex) remove(key []byte) {
+	terms, ok := si.terms[string(key)]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		si.entries.Delete(secondaryEntry{term: term, key: key})
+	}
+	delete(si.terms, string(key))
+}
+
+// CreateIndex 创建二级索引，extractor从每条数据中提取term，之后可以通过term查找主键
+// 创建时会从已有的数据中回填，之后在Put、Delete以及WriteBatch提交时同步更新
+// 二级索引只保存在内存中，每次Open之后需要重新创建，创建时会根据数据文件中的数据重建
+// 只包含默认命名空间中的数据
+func (db *DB) CreateIndex(name string, extractor IndexExtractor) error {
+	if name == "" {
+		return errors.New("secondary index name is empty")
+	}
+	if extractor == nil {
+		return errors.New("secondary index extractor is nil")
+	}
+
+	// 回填期间阻塞写入，保证二级索引与数据一致
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.secondaryIndexes[name]; ok {
+		return ErrSecondaryIndexExists
+	}
+
+	si := newSecondaryIndex(extractor)
+	if db.activeFile != nil {
+		iterator := db.index.Iterator(false)
+		defer iterator.Close()
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			value, err := db.getValueByPosition(iterator.Value())
+			if err != nil {
+				return err
+			}
+			si.update(iterator.Key(), value)
+		}
+	}
+	db.secondaryIndexes[name] = si
+	return nil
+}
+
+// DropIndex 删除二级索引
+func (db *DB) DropIndex(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.secondaryIndexes[name]; !ok {
+		return ErrSecondaryIndexNotFound
+	}
+	delete(db.secondaryIndexes, name)
+	return nil
+}
+
+// QueryIndex 查找二级索引中term对应的所有主键，按照主键的顺序排列
+func (db *DB) QueryIndex(name string, term []byte) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	si, ok := db.secondaryIndexes[name]
+	if !ok {
+		return nil, ErrSecondaryIndexNotFound
+	}
+	var keys [][]byte
+	si.entries.AscendGreaterOrEqual(secondaryEntry{term: term}, func(entry secondaryEntry) bool {
+		if !bytes.Equal(entry.term, term) {
+			return false
+		}
+		keys = append(keys, append([]byte(nil), entry.key...))
+		return true
+	})
+	return keys, nil
+}
+
+// QueryIndexRange 按照term的顺序遍历二级索引中[start, end)范围内的term以及对应的主键，fn返回false时终止遍历
+// start为nil时从第一个term开始，end为nil时遍历到最后，遍历期间持有读锁，fn中不能写入数据
+func (db *DB) QueryIndexRange(name string, start, end []byte, fn func(term, key []byte) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	si, ok := db.secondaryIndexes[name]
+	if !ok {
+		return ErrSecondaryIndexNotFound
+	}
+	si.entries.AscendGreaterOrEqual(secondaryEntry{term: start}, func(entry secondaryEntry) bool {
+		if end != nil && bytes.Compare(entry.term, end) >= 0 {
+			return false
+		}
+		return fn(entry.term, entry.key)
+	})
+	return nil
+}
+
+// updateSecondaryIndexes 默认命名空间中的key写入了新的value，需要持有db.mu
+func (db *DB) updateSecondaryIndexes(key, value []byte) {
+	for _, si := range db.secondaryIndexes {
+		si.update(key, value)
+	}
+}
+
+// removeFromSecondaryIndexes 默认命名空间中的key被删除，需要持有db.mu
+func (db *DB) removeFromSecondaryIndexes(key []byte) {
+	for _, si := range db.secondaryIndexes {
+		si.remove(key)
+	}
+}
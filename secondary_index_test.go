@@ -0,0 +1,87 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// value格式为 city:name，按照city建立索引
+func cityExtractor(key, value []byte) [][]byte {
+	i := bytes.IndexByte(value, ':')
+	if i < 0 {
+		return nil
+	}
+	return [][]byte{value[:i]}
+}
+
+func TestDB_SecondaryIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-secondary-index")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("u1"), []byte("beijing:alice")))
+	assert.Nil(t, db.Put([]byte("u2"), []byte("shanghai:bob")))
+
+	// 创建时回填已有的数据
+	assert.Nil(t, db.CreateIndex("city", cityExtractor))
+	assert.Equal(t, ErrSecondaryIndexExists, db.CreateIndex("city", cityExtractor))
+	keys, err := db.QueryIndex("city", []byte("beijing"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("u1")}, keys)
+
+	// 写入、更新以及删除时同步更新
+	assert.Nil(t, db.Put([]byte("u3"), []byte("beijing:carol")))
+	assert.Nil(t, db.Put([]byte("u1"), []byte("shenzhen:alice")))
+	assert.Nil(t, db.Delete([]byte("u2")))
+	keys, err = db.QueryIndex("city", []byte("beijing"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("u3")}, keys)
+	keys, err = db.QueryIndex("city", []byte("shanghai"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("u4"), []byte("shenzhen:dave")))
+	assert.Nil(t, wb.Delete([]byte("u3")))
+	assert.Nil(t, wb.Commit())
+	keys, err = db.QueryIndex("city", []byte("shenzhen"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("u1"), []byte("u4")}, keys)
+	keys, err = db.QueryIndex("city", []byte("beijing"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+
+	// 按照term的范围遍历
+	assert.Nil(t, db.Put([]byte("u5"), []byte("hangzhou:eve")))
+	var terms []string
+	assert.Nil(t, db.QueryIndexRange("city", []byte("h"), []byte("shenzhen"), func(term, key []byte) bool {
+		terms = append(terms, string(term)+"/"+string(key))
+		return true
+	}))
+	assert.Equal(t, []string{"hangzhou/u5"}, terms)
+	terms = terms[:0]
+	assert.Nil(t, db.QueryIndexRange("city", nil, nil, func(term, key []byte) bool {
+		terms = append(terms, string(term)+"/"+string(key))
+		return true
+	}))
+	assert.Equal(t, []string{"hangzhou/u5", "shenzhen/u1", "shenzhen/u4"}, terms)
+
+	assert.Nil(t, db.DropIndex("city"))
+	_, err = db.QueryIndex("city", []byte("shenzhen"))
+	assert.Equal(t, ErrSecondaryIndexNotFound, err)
+	assert.Nil(t, db.Close())
+
+	// 重启之后重新创建，从数据文件中重建
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.CreateIndex("city", cityExtractor))
+	keys, err = db.QueryIndex("city", []byte("shenzhen"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("u1"), []byte("u4")}, keys)
+	assert.Nil(t, db.Close())
+}
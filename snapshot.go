@@ -0,0 +1,318 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bufio"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	snapshotFinishedKey = "index.snapshot.finished"
+	snapshotTempSuffix  = ".tmp"
+)
+
+// indexWatermark 索引快照覆盖到的数据位置，该位置之前写入的数据都已经包含在快照中
+type indexWatermark struct {
+	fid    uint32
+	offset int64
+}
+
+// snapshotFooter 快照文件最后一条记录，标识快照写入完成
+type snapshotFooter struct {
+	watermark   indexWatermark
+	seqNo       uint64
+	reclaimSize int64
+	count       uint64
+	fileGarbage map[uint32]int64
+	txnSpans    map[uint32]uint32 // 水位线之前跨越多个数据文件的事务，旧版本的快照中没有，解码之后为nil
+}
+
+func encodeSnapshotFooter(footer *snapshotFooter) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*5+
+		len(footer.fileGarbage)*(binary.MaxVarintLen32+binary.MaxVarintLen64)+
+		binary.MaxVarintLen64+len(footer.txnSpans)*binary.MaxVarintLen32*2)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(footer.watermark.fid))
+	index += binary.PutVarint(buf[index:], footer.watermark.offset)
+	index += binary.PutUvarint(buf[index:], footer.seqNo)
+	index += binary.PutVarint(buf[index:], footer.reclaimSize)
+	index += binary.PutUvarint(buf[index:], footer.count)
+	// 每个数据文件的待回收字节数
+	index += binary.PutUvarint(buf[index:], uint64(len(footer.fileGarbage)))
+	for fid, size := range footer.fileGarbage {
+		index += binary.PutUvarint(buf[index:], uint64(fid))
+		index += binary.PutVarint(buf[index:], size)
+	}
+	// 跨越多个数据文件的事务
+	index += binary.PutUvarint(buf[index:], uint64(len(footer.txnSpans)))
+	for lastFid, firstFid := range footer.txnSpans {
+		index += binary.PutUvarint(buf[index:], uint64(lastFid))
+		index += binary.PutUvarint(buf[index:], uint64(firstFid))
+	}
+	return buf[:index]
+}
+
+func decodeSnapshotFooter(buf []byte) *snapshotFooter {
+	var index = 0
+	fid, n := binary.Uvarint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	index += n
+	reclaimSize, n := binary.Varint(buf[index:])
+	index += n
+	count, n := binary.Uvarint(buf[index:])
+	index += n
+	garbageNum, n := binary.Uvarint(buf[index:])
+	index += n
+	fileGarbage := make(map[uint32]int64, garbageNum)
+	for i := uint64(0); i < garbageNum; i++ {
+		garbageFid, n := binary.Uvarint(buf[index:])
+		index += n
+		size, n := binary.Varint(buf[index:])
+		index += n
+		fileGarbage[uint32(garbageFid)] = size
+	}
+	var txnSpans map[uint32]uint32
+	if index < len(buf) {
+		txnSpans = make(map[uint32]uint32)
+		spanNum, n := binary.Uvarint(buf[index:])
+		index += n
+		for i := uint64(0); i < spanNum; i++ {
+			lastFid, n := binary.Uvarint(buf[index:])
+			index += n
+			firstFid, n := binary.Uvarint(buf[index:])
+			index += n
+			txnSpans[uint32(lastFid)] = uint32(firstFid)
+		}
+	}
+	return &snapshotFooter{
+		watermark:   indexWatermark{fid: uint32(fid), offset: offset},
+		seqNo:       seqNo,
+		reclaimSize: reclaimSize,
+		count:       count,
+		fileGarbage: fileGarbage,
+		txnSpans:    txnSpans,
+	}
+}
+
+// SnapshotIndex 将内存索引完整地写入快照文件
+// 重启时加载快照，只需要回放快照水位线之后写入的数据
+func (db *DB) SnapshotIndex() error {
+	// B+树索引本身就存储在磁盘上，不需要快照
+	if db.options.IndexType == BPlusTree {
+		return nil
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	// 持有写锁获取水位线以及索引的迭代器，写操作都在持有写锁时更新索引或者锁住分片索引的写入顺序，保证两者一致
+	db.mu.Lock()
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	footer := &snapshotFooter{
+		watermark: indexWatermark{fid: db.activeFile.FileId, offset: db.activeFile.WriteOff},
+		seqNo:     db.seqNo,
+		txnSpans:  make(map[uint32]uint32, len(db.txnSpans)),
+	}
+	for lastFid, firstFid := range db.txnSpans {
+		footer.txnSpans[lastFid] = firstFid
+	}
+	// 没有新的写入，不需要重新生成快照
+	if db.lastSnapshot != nil && *db.lastSnapshot == footer.watermark {
+		db.mu.Unlock()
+		return nil
+	}
+	// 快照引用的数据必须已经持久化
+	if err := db.syncActiveFile(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	// 依次写入每个命名空间的索引
+	indexes := db.namespaceIndexes()
+	iterators := make(map[uint32]index.Iterator, len(indexes))
+	for nsId, idx := range indexes {
+		iterators[nsId] = idx.Iterator(false)
+	}
+	// 分片索引创建迭代器时等待了db.mu之外还没有完成的更新，之后再读取待回收的字节数
+	db.garbageMu.Lock()
+	footer.reclaimSize = db.reclaimSize
+	footer.fileGarbage = make(map[uint32]int64, len(db.fileGarbage))
+	for fid, size := range db.fileGarbage {
+		footer.fileGarbage[fid] = size
+	}
+	db.garbageMu.Unlock()
+	db.mu.Unlock()
+	defer func() {
+		for _, iterator := range iterators {
+			iterator.Close()
+		}
+	}()
+
+	// 先写入临时文件，完成后再重命名，保证快照文件是完整的
+	fileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	tempFileName := fileName + snapshotTempSuffix
+	file, err := os.OpenFile(tempFileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(tempFileName)
+	}()
+
+	writer := bufio.NewWriterSize(file, 1024*1024)
+	for nsId, iterator := range iterators {
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			encRecord := encodeNamespaceHintRecord(nsId, iterator.Key(), iterator.Value(), data.LogRecordNormal)
+			if _, err := writer.Write(encRecord); err != nil {
+				return err
+			}
+			footer.count++
+		}
+	}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(snapshotFinishedKey),
+		Value: encodeSnapshotFooter(footer),
+		Type:  data.LogRecordTxnFinished,
+	})
+	if _, err := writer.Write(encRecord); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFileName, fileName); err != nil {
+		return err
+	}
+	// 重命名之后持久化数据目录，崩溃之后快照文件仍然存在
+	if err := utils.SyncDir(db.options.DirPath); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	db.lastSnapshot = &footer.watermark
+	db.mu.Unlock()
+	return nil
+}
+
+// loadIndexFromSnapshot 从快照文件中加载索引，返回快照的水位线，快照不存在或者无效时返回nil
+func (db *DB) loadIndexFromSnapshot() (*indexWatermark, error) {
+	fileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+	snapshotFile, err := data.OpenIndexSnapshotFile(db.options.DirPath, data.IndexSnapshotFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshotFile.Close()
+
+	var offset int64 = 0
+	var footer *snapshotFooter
+	var count uint64
+	batches := db.newIndexBatches(nil)
+	for {
+		record, size, err := snapshotFile.ReadLogRecord(offset)
+		// 读取出错说明快照已经损坏，直接丢弃
+		if err != nil {
+			break
+		}
+		if record.Type == data.LogRecordTxnFinished {
+			footer = decodeSnapshotFooter(record.Value)
+			break
+		}
+		// 快照之后被删除的命名空间直接跳过
+		nsId := splitNamespace(record)
+		batches.add(nsId, index.BatchOp{
+			Key:  record.Key,
+			Pos:  data.DecodeLogRecordPos(record.Value),
+			Type: data.LogRecordNormal,
+		})
+		count++
+		offset += size
+	}
+	batches.flush()
+
+	// 旧版本的快照中没有记录跨越多个数据文件的事务，同样按正常流程加载
+	if footer == nil || footer.count != count || footer.txnSpans == nil || !db.snapshotUsable(footer.watermark) {
+		// 快照无效，重置索引后按正常流程加载
+		if err := db.resetIndexes(); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	db.seqNo = footer.seqNo
+	db.reclaimSize = footer.reclaimSize
+	db.fileGarbage = footer.fileGarbage
+	db.txnSpans = footer.txnSpans
+	db.lastSnapshot = &footer.watermark
+	return &footer.watermark, nil
+}
+
+// 水位线所在的数据文件必须存在，且已经写入到水位线的位置
+func (db *DB) snapshotUsable(watermark indexWatermark) bool {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == watermark.fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[watermark.fid]
+	}
+	if dataFile == nil {
+		return false
+	}
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		return false
+	}
+	return size >= watermark.offset
+}
+
+// removeIndexSnapshot 数据文件被重写之后，快照中的位置信息已经失效，运行期间调用需要持有db.snapshotLock以及db.mu
+func (db *DB) removeIndexSnapshot() error {
+	fileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db.lastSnapshot = nil
+	return nil
+}
+
+// startIndexSnapshot 后台定期生成索引快照
+func (db *DB) startIndexSnapshot() {
+	db.bgWait.Add(1)
+	go func() {
+		defer db.bgWait.Done()
+		ticker := time.NewTicker(db.options.IndexSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := db.SnapshotIndex(); err != nil {
+					db.logger.Error("index snapshot failed", "dir", db.options.DirPath, "err", err)
+					if fn := db.options.EventListener.OnSnapshotFailed; fn != nil {
+						fn(err)
+					}
+				}
+			case <-db.closeCh:
+				return
+			}
+		}
+	}()
+}
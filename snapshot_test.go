@@ -0,0 +1,99 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_SnapshotIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.SnapshotIndex())
+	watermark := *db.lastSnapshot
+
+	// 快照之后继续写入，重启时需要回放这部分数据
+	for i := 1000; i < 1500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(200)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2000), []byte("batch")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(300)))
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, watermark, *db.lastSnapshot)
+	assert.Equal(t, seqNo, db.seqNo)
+	for i := 0; i < 1500; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		if i < 100 || i == 200 || i == 300 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	val, err := db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	assert.Equal(t, 1399, len(db.ListKeys()))
+
+	// 继续写入并在关闭时生成快照
+	assert.Nil(t, db.Put([]byte("after-reopen"), []byte("v")))
+	assert.Nil(t, db.Close())
+
+	// 快照损坏时丢弃快照，从数据文件中完整加载
+	fileName := filepath.Join(dir, data.IndexSnapshotFileName)
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(fileName, buf[:len(buf)/2], 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.lastSnapshot)
+	assert.Equal(t, 1400, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_IndexSnapshotInterval(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexSnapshotInterval = time.Millisecond * 10
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, data.IndexSnapshotFileName))
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db.lastSnapshot)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
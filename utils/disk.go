@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// DirSize 获取一个目录中所有文件的大小之和
+func DirSize(dirPath string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dirPath, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// DirDiskUsage 获取一个目录中所有文件实际占用的磁盘空间，包括预分配但是还没有写入的空间，
+// 不支持获取分配块数的平台上与 DirSize 相同
+func DirDiskUsage(dirPath string) (uint64, error) {
+	var usage uint64
+	err := filepath.WalkDir(dirPath, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage += fileDiskUsage(info)
+		return nil
+	})
+	return usage, err
+}
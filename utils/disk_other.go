@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package utils
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// AvailableDiskSize 当前平台不支持获取剩余空间
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	return 0, errors.New("available disk size is not supported on this platform")
+}
+
+func fileDiskUsage(info fs.FileInfo) uint64 {
+	return uint64(info.Size())
+}
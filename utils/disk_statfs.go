@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd
+
+package utils
+
+import (
+	"golang.org/x/sys/unix"
+	"io/fs"
+	"syscall"
+)
+
+// AvailableDiskSize 获取dirPath所在文件系统中当前用户可以使用的剩余空间大小
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dirPath, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// fileDiskUsage 文件实际分配的块数，按512字节一块计算
+func fileDiskUsage(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Blocks) * 512
+	}
+	return uint64(info.Size())
+}
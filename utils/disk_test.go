@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirSize(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-utils")
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "a.data"), make([]byte, 10000), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "sub", "b.data"), make([]byte, 100), 0644))
+
+	size, err := DirSize(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10100), size)
+
+	// 按块分配，实际占用的空间不小于文件大小
+	usage, err := DirDiskUsage(dir)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, usage, uint64(10100))
+
+	_, err = DirSize(filepath.Join(dir, "not-exist"))
+	assert.NotNil(t, err)
+	_, err = DirDiskUsage(filepath.Join(dir, "not-exist"))
+	assert.NotNil(t, err)
+}
+
+func TestAvailableDiskSize(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-utils")
+	defer os.RemoveAll(dir)
+
+	size, err := AvailableDiskSize(dir)
+	assert.Nil(t, err)
+	assert.Greater(t, size, uint64(0))
+
+	_, err = AvailableDiskSize(filepath.Join(dir, "not-exist"))
+	assert.NotNil(t, err)
+}
+
+func TestSyncDir(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-utils")
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "a.tmp"), []byte("a"), 0644))
+	assert.Nil(t, os.Rename(filepath.Join(dir, "a.tmp"), filepath.Join(dir, "a")))
+	assert.Nil(t, SyncDir(dir))
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package utils
+
+import (
+	"golang.org/x/sys/windows"
+	"io/fs"
+)
+
+// AvailableDiskSize 获取dirPath所在磁盘中当前用户可以使用的剩余空间大小
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytesAvailable, totalNumberOfBytes, totalNumberOfFreeBytes uint64
+	err = windows.GetDiskFreeSpaceEx(pathPtr, &freeBytesAvailable, &totalNumberOfBytes, &totalNumberOfFreeBytes)
+	if err != nil {
+		return 0, err
+	}
+	return freeBytesAvailable, nil
+}
+
+func fileDiskUsage(info fs.FileInfo) uint64 {
+	return uint64(info.Size())
+}
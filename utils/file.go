@@ -1,79 +1,58 @@
 package utils
 
 import (
-	"golang.org/x/sys/windows"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-	"syscall"
-	"unsafe"
 )
 
-// DirSize 获取一个目录的大小
-func DirSize(dirPath string) (int64, error) {
-	var size int64
-	err := filepath.Walk(dirPath, func(_ string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			size += info.Size()
-		}
+// MoveFile 移动文件，src和dst不在同一个文件系统时无法重命名，改为拷贝并持久化之后删除源文件
+// 拷贝时先写入临时文件再重命名，中途退出时dst要么不存在要么是完整的，可以重复执行
+// 拷贝时在删除源文件之前持久化dst所在的目录；直接重命名时需要调用方持久化目录
+func MoveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDeviceError(err) {
 		return err
-	})
-	return size, err
-}
+	}
 
-// AvailableDiskSize 获取磁盘剩余可用空间大小（兼容Windows和Unix-like系统）
-func AvailableDiskSize() (uint64, error) {
-	// 获取当前工作目录
-	wd, err := syscall.Getwd()
+	srcFile, err := os.Open(src)
 	if err != nil {
-		return 0, err
+		return err
 	}
-
-	// 根据操作系统选择不同的实现
-	//if windowsAvailable, err := getWindowsAvailableDiskSize(wd); err == nil {
-	//	return windowsAvailable, nil
-	//}
-	return getWindowsAvailableDiskSize(wd)
-
-	// 如果Windows实现失败或非Windows系统，尝试Unix-like实现
-	//return getUnixAvailableDiskSize(wd)
-}
-
-// Windows实现
-func getWindowsAvailableDiskSize(path string) (uint64, error) {
-	// 将路径转换为UTF16指针
-	pathPtr, err := windows.UTF16PtrFromString(path)
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	var freeBytesAvailable, totalNumberOfBytes, totalNumberOfFreeBytes uint64
-
-	// 调用Windows API GetDiskFreeSpaceExW
-	ret, _, err := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW").Call(
-		uintptr(unsafe.Pointer(pathPtr)),
-		uintptr(unsafe.Pointer(&freeBytesAvailable)),
-		uintptr(unsafe.Pointer(&totalNumberOfBytes)),
-		uintptr(unsafe.Pointer(&totalNumberOfFreeBytes)),
-	)
-
-	if ret == 0 {
-		return 0, err
+	tempFile := dst + ".tmp"
+	dstFile, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
 	}
-
-	return freeBytesAvailable, nil
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+	if err := dstFile.Sync(); err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, dst); err != nil {
+		return err
+	}
+	if err := SyncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	_ = srcFile.Close()
+	return os.Remove(src)
 }
 
-// Unix-like实现
-//func getUnixAvailableDiskSize(path string) (uint64, error) {
-//	var stat syscall.Statfs_t
-//	if err := syscall.Statfs(path, &stat); err != nil {
-//		return 0, err
-//	}
-//	return stat.Bavail * uint64(stat.Bsize), nil
-//}
-
 func CopyDir(src, dst string, exclude []string) error {
 	// 目标文件不存在则创建
 	if _, err := os.Stat(dst); os.IsNotExist(err) {
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// isCrossDeviceError 判断是否是跨文件系统重命名导致的错误
+func isCrossDeviceError(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// SyncDir 持久化目录项，保证目录中文件的创建、重命名以及删除在崩溃之后仍然存在
+func SyncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
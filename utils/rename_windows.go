@@ -0,0 +1,18 @@
+//go:build windows
+
+package utils
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+)
+
+// isCrossDeviceError 判断是否是跨磁盘重命名导致的错误
+func isCrossDeviceError(err error) bool {
+	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE)
+}
+
+// SyncDir Windows上不能对目录调用FlushFileBuffers，重命名的元数据由NTFS日志保证
+func SyncDir(dirPath string) error {
+	return nil
+}
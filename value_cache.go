@@ -0,0 +1,139 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	valueCacheShardNum = 16
+	// 每条缓存除了key和value之外占用内存的估算值
+	valueCacheEntryOverhead = 64
+)
+
+// valueCache 按数据位置缓存读取到的记录，分片减少锁竞争，每个分片独立按LRU淘汰
+// 数据文件写入之后不会被修改，文件id也不会被重复使用，只有数据文件被删除时需要清除对应的缓存
+type valueCache struct {
+	shards []*valueCacheShard
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type valueCacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+type valueCacheEntry struct {
+	key    valueCacheKey
+	record *data.LogRecord
+	size   int64
+}
+
+type valueCacheShard struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	items    map[valueCacheKey]*list.Element
+	lru      *list.List // 最近访问的在最前面
+}
+
+// newValueCache capacity为所有分片总共可以使用的字节数
+func newValueCache(capacity int64) *valueCache {
+	cache := &valueCache{shards: make([]*valueCacheShard, valueCacheShardNum)}
+	for i := range cache.shards {
+		cache.shards[i] = &valueCacheShard{
+			capacity: capacity / valueCacheShardNum,
+			items:    make(map[valueCacheKey]*list.Element),
+			lru:      list.New(),
+		}
+	}
+	return cache
+}
+
+func (c *valueCache) shard(key valueCacheKey) *valueCacheShard {
+	h := (uint64(key.fid)<<40 ^ uint64(key.offset)) * 0x9E3779B97F4A7C15
+	return c.shards[h>>60]
+}
+
+// get 返回缓存记录的拷贝，调用方可以修改
+func (c *valueCache) get(pos *data.LogRecordPos) (*data.LogRecord, bool) {
+	key := valueCacheKey{fid: pos.Fid, offset: pos.Offset}
+	s := c.shard(key)
+	s.mu.Lock()
+	elem, ok := s.items[key]
+	if ok {
+		s.lru.MoveToFront(elem)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return copyLogRecord(elem.Value.(*valueCacheEntry).record), true
+}
+
+func (c *valueCache) put(pos *data.LogRecordPos, record *data.LogRecord) {
+	key := valueCacheKey{fid: pos.Fid, offset: pos.Offset}
+	size := int64(len(record.Key)+len(record.Value)) + valueCacheEntryOverhead
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 超过分片容量的记录不缓存
+	if size > s.capacity {
+		return
+	}
+	if _, ok := s.items[key]; ok {
+		return
+	}
+	s.items[key] = s.lru.PushFront(&valueCacheEntry{key: key, record: copyLogRecord(record), size: size})
+	s.size += size
+	for s.size > s.capacity {
+		s.remove(s.lru.Back())
+	}
+}
+
+// evictFile 数据文件被删除之后清除其中所有记录的缓存
+func (c *valueCache) evictFile(fid uint32) {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for elem := s.lru.Front(); elem != nil; {
+			next := elem.Next()
+			if elem.Value.(*valueCacheEntry).key.fid == fid {
+				s.remove(elem)
+			}
+			elem = next
+		}
+		s.mu.Unlock()
+	}
+}
+
+// size 所有分片当前缓存的字节数
+func (c *valueCache) size() int64 {
+	var total int64
+	for _, s := range c.shards {
+		s.mu.Lock()
+		total += s.size
+		s.mu.Unlock()
+	}
+	return total
+}
+
+// copyLogRecord 缓存中的记录不能和调用方共享内存
+func copyLogRecord(record *data.LogRecord) *data.LogRecord {
+	buf := make([]byte, len(record.Key)+len(record.Value))
+	n := copy(buf, record.Key)
+	copy(buf[n:], record.Value)
+	return &data.LogRecord{Key: buf[:n:n], Value: buf[n:], Type: record.Type}
+}
+
+func (s *valueCacheShard) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*valueCacheEntry)
+	delete(s.items, entry.key)
+	s.size -= entry.size
+}
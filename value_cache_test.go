@@ -0,0 +1,91 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestValueCache_Evict(t *testing.T) {
+	cache := newValueCache(valueCacheShardNum * (valueCacheEntryOverhead + 10) * 2)
+	record := &data.LogRecord{Key: []byte("k"), Value: []byte("123456789")}
+
+	// 同一个分片最多缓存两条记录，最久没有访问的被淘汰
+	var positions []*data.LogRecordPos
+	for offset := int64(0); len(positions) < 3; offset++ {
+		pos := &data.LogRecordPos{Fid: 1, Offset: offset}
+		if len(positions) == 0 || cache.shard(valueCacheKey{fid: 1, offset: offset}) ==
+			cache.shard(valueCacheKey{fid: 1, offset: positions[0].Offset}) {
+			positions = append(positions, pos)
+		}
+	}
+	cache.put(positions[0], record)
+	cache.put(positions[1], record)
+	_, ok := cache.get(positions[0])
+	assert.True(t, ok)
+	cache.put(positions[2], record)
+	_, ok = cache.get(positions[1])
+	assert.False(t, ok)
+	cached, ok := cache.get(positions[0])
+	assert.True(t, ok)
+	assert.Equal(t, record, cached)
+
+	// 返回的是拷贝，修改之后不影响缓存
+	cached.Value[0] = 'x'
+	cached, _ = cache.get(positions[0])
+	assert.Equal(t, []byte("123456789"), cached.Value)
+
+	cache.evictFile(1)
+	assert.Equal(t, int64(0), cache.size())
+	assert.Equal(t, uint64(3), cache.hits.Load())
+	assert.Equal(t, uint64(1), cache.misses.Load())
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-cache")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0.3
+	opts.ValueCacheSize = 1024 * 1024
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+	}
+	stat := db.Stat()
+	assert.Equal(t, uint64(100), stat.CacheMisses)
+	assert.Equal(t, uint64(200), stat.CacheHits)
+	assert.Greater(t, stat.CacheSize, int64(0))
+
+	// 文件压缩删除数据文件之后，对应的缓存被清除，读取重写之后的数据
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	val, err := db.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+	assert.Nil(t, db.CompactFiles(100))
+	for i := 0; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		if i < 500 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	newVal, err := db.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+	assert.Equal(t, val, newVal)
+}